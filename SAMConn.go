@@ -1,7 +1,10 @@
 package sam3
 
 import (
+	"errors"
+	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/eyedeekay/i2pkeys"
@@ -17,23 +20,112 @@ type SAMConn struct {
 	laddr i2pkeys.I2PAddr
 	raddr i2pkeys.I2PAddr
 	conn  net.Conn
+	// limits and counts the bytes read and written, and the ones of the
+	// session for all its connections
+	in, out               *RateLimiter
+	sessionIn, sessionOut *RateLimiter
+	// called once when the connection is closed, if set
+	onClose   func()
+	closeOnce sync.Once
+	// the session the connection belongs to, nil if none
+	session *StreamSession
 }
 
 // Implements net.Conn
 func (sc *SAMConn) Read(buf []byte) (int, error) {
+	buf = buf[:sc.sessionIn.chunk(sc.in.chunk(len(buf)))]
 	n, err := sc.conn.Read(buf)
+	// reading less while over the limit makes the peer slow down
+	sc.in.take(n)
+	sc.sessionIn.take(n)
 	return n, err
 }
 
 // Implements net.Conn
 func (sc *SAMConn) Write(buf []byte) (int, error) {
-	n, err := sc.conn.Write(buf)
-	return n, err
+	written := 0
+	for len(buf) > 0 {
+		chunk := buf[:sc.sessionOut.chunk(sc.out.chunk(len(buf)))]
+		sc.out.take(len(chunk))
+		sc.sessionOut.take(len(chunk))
+		n, err := sc.conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		buf = buf[n:]
+	}
+	return written, nil
+}
+
+// InboundLimiter limits and counts the bytes read from the connection.
+func (sc *SAMConn) InboundLimiter() *RateLimiter {
+	return sc.in
+}
+
+// OutboundLimiter limits and counts the bytes written to the connection.
+func (sc *SAMConn) OutboundLimiter() *RateLimiter {
+	return sc.out
 }
 
 // Implements net.Conn
 func (sc *SAMConn) Close() error {
-	return sc.conn.Close()
+	err := sc.conn.Close()
+	sc.closeOnce.Do(func() {
+		if sc.onClose != nil {
+			sc.onClose()
+		}
+		if sc.session != nil {
+			sc.session.untrack(sc)
+		}
+	})
+	return err
+}
+
+// CloseWrite shuts down the writing side, the peer reads EOF while data can
+// still be read from it. It fails if the connection to the SAM bridge can not
+// be half-closed.
+func (sc *SAMConn) CloseWrite() error {
+	if cw, ok := sc.conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("SAM connection can not be half-closed")
+}
+
+// CloseRead shuts down the reading side. It fails if the connection to the SAM
+// bridge can not be half-closed.
+func (sc *SAMConn) CloseRead() error {
+	if cr, ok := sc.conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return errors.New("SAM connection can not be half-closed")
+}
+
+// Implements io.ReaderFrom. If neither the connection nor the session has an
+// outbound limit and the socket to the SAM bridge has a fast path, such as
+// splice, the data takes it and is counted once the copy is done. Otherwise
+// it goes through Write, so it is limited. A limit set during a fast copy
+// applies from the next one on.
+func (sc *SAMConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := sc.conn.(io.ReaderFrom); ok && sc.out.unlimited() && sc.sessionOut.unlimited() {
+		n, err := rf.ReadFrom(r)
+		sc.out.count(n)
+		sc.sessionOut.count(n)
+		return n, err
+	}
+	return io.Copy(struct{ io.Writer }{sc}, r)
+}
+
+// Implements io.WriterTo, with a fast path like ReadFrom when there is no
+// inbound limit.
+func (sc *SAMConn) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := sc.conn.(io.WriterTo); ok && sc.in.unlimited() && sc.sessionIn.unlimited() {
+		n, err := wt.WriteTo(w)
+		sc.in.count(n)
+		sc.sessionIn.count(n)
+		return n, err
+	}
+	return io.Copy(w, struct{ io.Reader }{sc})
 }
 
 func (sc *SAMConn) LocalAddr() net.Addr {
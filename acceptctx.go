@@ -0,0 +1,158 @@
+package sam3
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// AcceptContext waits for and returns the next connection to the listener,
+// like Accept. If ctx is done or the deadline of the listener passes first,
+// only the pending STREAM ACCEPT is closed, and the error is a net.Error whose
+// Timeout is true.
+func (l *StreamListener) AcceptContext(ctx context.Context) (net.Conn, error) {
+	conn, err := l.AcceptContextI2P(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// AcceptContextI2P is AcceptContext, returning the *SAMConn.
+func (l *StreamListener) AcceptContextI2P(ctx context.Context) (*SAMConn, error) {
+	if ctx == nil {
+		panic("nil context")
+	}
+	return l.acceptI2P(ctx)
+}
+
+// SetDeadline sets when accepts time out, the ones in progress too, like
+// the SetDeadline of a net.TCPListener. The zero time means never.
+func (l *StreamListener) SetDeadline(t time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deadline = t
+	for conn, ctx := range l.pending {
+		if ctx.Err() == nil {
+			conn.SetDeadline(earliest(t, ctx))
+		}
+	}
+	return nil
+}
+
+// reports whether the listener is closed
+func (l *StreamListener) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// reports whether the deadline of the listener has passed
+func (l *StreamListener) expired() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.deadline.IsZero() && !time.Now().Before(l.deadline)
+}
+
+// connects to the bridge for an accept, within the deadline of the listener
+// and of ctx
+func (l *StreamListener) newSAM(ctx context.Context) (*SAM, error) {
+	l.mu.Lock()
+	deadline := l.deadline
+	l.mu.Unlock()
+	dialCtx := ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	s, err := l.session.newSAM(dialCtx)
+	if err != nil {
+		if ctx.Err() == nil && dialCtx.Err() != nil {
+			return nil, &acceptTimeout{os.ErrDeadlineExceeded}
+		}
+		return nil, l.acceptErr(ctx, err)
+	}
+	return s, nil
+}
+
+// makes the accept on conn time out with the deadline of the listener or of
+// ctx, or when ctx is done, and Close close it. The returned function stops
+// that, and reports whether ctx was done first. It can be called more than
+// once. It fails with net.ErrClosed if the listener is closed.
+func (l *StreamListener) watch(ctx context.Context, conn net.Conn) (func() bool, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	if l.pending == nil {
+		l.pending = make(map[net.Conn]context.Context)
+	}
+	l.pending[conn] = ctx
+	conn.SetDeadline(earliest(l.deadline, ctx))
+	l.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() {
+		// under mu, so SetDeadline can not undo it
+		l.mu.Lock()
+		conn.SetDeadline(aLongTimeAgo)
+		l.mu.Unlock()
+	})
+	var once sync.Once
+	interrupted := false
+	return func() bool {
+		once.Do(func() {
+			interrupted = !stop()
+			l.mu.Lock()
+			delete(l.pending, conn)
+			if !interrupted {
+				// the connection may be the peer's now
+				conn.SetDeadline(time.Time{})
+			}
+			l.mu.Unlock()
+		})
+		return interrupted
+	}, nil
+}
+
+// returns the earlier of t and the deadline of ctx, the zero time if neither
+// has one
+func earliest(t time.Time, ctx context.Context) time.Time {
+	if d, ok := ctx.Deadline(); ok && (t.IsZero() || d.Before(t)) {
+		return d
+	}
+	return t
+}
+
+// tells why an accept failed with err: because the listener or the session
+// was closed, ctx was done, or the deadline passed
+func (l *StreamListener) acceptErr(ctx context.Context, err error) error {
+	if l.isClosed() || l.session.closing() {
+		return net.ErrClosed
+	}
+	if ctx.Err() != nil {
+		return &acceptTimeout{ctx.Err()}
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			// the connection timed out before the context did
+			return &acceptTimeout{context.DeadlineExceeded}
+		}
+		return &acceptTimeout{os.ErrDeadlineExceeded}
+	}
+	return err
+}
+
+// acceptTimeout is the error of an accept that was given up on, a net.Error
+// whose Timeout is true. It unwraps to the context's error or to
+// os.ErrDeadlineExceeded.
+type acceptTimeout struct {
+	err error
+}
+
+func (e *acceptTimeout) Error() string   { return "sam3: accept: " + e.err.Error() }
+func (e *acceptTimeout) Unwrap() error   { return e.err }
+func (e *acceptTimeout) Timeout() bool   { return true }
+func (e *acceptTimeout) Temporary() bool { return true }
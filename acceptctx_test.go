@@ -0,0 +1,104 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func Test_AcceptContext(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	b.mu.Lock()
+	b.idle = true
+	b.mu.Unlock()
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.AcceptContext(ctx); !isTimeout(err) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("accept past the context's deadline: %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := l.AcceptContext(ctx); !isTimeout(err) || !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled accept: %v", err)
+	}
+
+	// a deadline set while the accept is pending
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.AcceptI2P()
+		accepted <- err
+	}()
+	for b.count("STREAM ACCEPT") < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	l.SetDeadline(time.Now().Add(20 * time.Millisecond))
+	select {
+	case err := <-accepted:
+		if !isTimeout(err) || !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("accept past the listener's deadline: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SetDeadline did not interrupt the pending accept")
+	}
+	if _, err := l.AcceptI2P(); !isTimeout(err) {
+		t.Errorf("accept after the deadline: %v", err)
+	}
+
+	// only the accepts were given up on, not the session
+	l.SetDeadline(time.Time{})
+	b.mu.Lock()
+	b.idle = false
+	b.mu.Unlock()
+	conn, err := l.AcceptContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func Test_AcceptDialTimeout(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.AcceptContext(ctx); !isTimeout(err) || !errors.Is(err, context.Canceled) {
+		t.Errorf("accept with a canceled context: %v", err)
+	}
+
+	// the bridge does not answer HELLO
+	b.mu.Lock()
+	b.mute = true
+	b.mu.Unlock()
+	l.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := l.AcceptI2P(); !isTimeout(err) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("accept past the listener's deadline while connecting: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("accept took %v past the deadline", d)
+	}
+	l.SetDeadline(time.Time{})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := l.AcceptContext(ctx); !isTimeout(err) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("accept past the context's deadline while connecting: %v", err)
+	}
+}
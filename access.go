@@ -0,0 +1,236 @@
+package sam3
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// Reasons an AccessPolicy turns a connection away, passed to its OnReject.
+var (
+	ErrAccessDenied = errors.New("Destination not allowed")
+	ErrRateLimited  = errors.New("Destination connects too often")
+	ErrTooManyConns = errors.New("Destination has too many open connections")
+)
+
+// AccessPolicy decides which destinations may connect to a StreamListener,
+// and how often and how many at once. Unlike the i2cp access list options it
+// is applied by the listener itself, so it can be changed while the session
+// runs. Rejected connections are closed as soon as they are accepted.
+type AccessPolicy struct {
+	// OnReject, if set, is called with every destination turned away and
+	// the reason. It is called from Accept, so it should not block.
+	OnReject func(addr i2pkeys.I2PAddr, reason error)
+
+	mu         sync.Mutex
+	file       string
+	listType   string
+	list       map[i2pkeys.I2PDestHash]bool
+	perMinute  int
+	concurrent int
+	peers      map[i2pkeys.I2PDestHash]*peerAccess
+	swept      time.Time
+}
+
+// connections of one destination
+type peerAccess struct {
+	// when it connected during the last minute
+	recent []time.Time
+	active int
+}
+
+// NewAccessPolicy returns a policy using entries as the access list. With
+// listType "whitelist" only the listed destinations are let in, with
+// "blacklist" everyone else is, and with "" the list is ignored. Entries are
+// .b32.i2p addresses, base64 destination hashes or full base64 destinations.
+func NewAccessPolicy(listType string, entries ...string) (*AccessPolicy, error) {
+	p := &AccessPolicy{peers: make(map[i2pkeys.I2PDestHash]*peerAccess)}
+	if err := p.SetList(listType, entries); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadAccessPolicy returns a policy with the access list read from fname, one
+// entry per line. Empty lines and lines starting with # are skipped. Reload
+// reads the file again.
+func LoadAccessPolicy(fname, listType string) (*AccessPolicy, error) {
+	entries, err := readAccessList(fname)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewAccessPolicy(listType, entries...)
+	if err != nil {
+		return nil, err
+	}
+	p.file = fname
+	return p, nil
+}
+
+// Reload reads the access list file again. If it can not be read or has an
+// invalid entry, the current list is kept.
+func (p *AccessPolicy) Reload() error {
+	p.mu.Lock()
+	fname, listType := p.file, p.listType
+	p.mu.Unlock()
+	if fname == "" {
+		return errors.New("Access policy was not loaded from a file")
+	}
+	entries, err := readAccessList(fname)
+	if err != nil {
+		return err
+	}
+	return p.SetList(listType, entries)
+}
+
+// SetList replaces the access list.
+func (p *AccessPolicy) SetList(listType string, entries []string) error {
+	switch listType {
+	case "whitelist", "blacklist", "":
+	default:
+		return errors.New("Invalid access list type " + listType)
+	}
+	list := make(map[i2pkeys.I2PDestHash]bool, len(entries))
+	for _, e := range entries {
+		h, err := parseAccessEntry(e)
+		if err != nil {
+			return err
+		}
+		list[h] = true
+	}
+	p.mu.Lock()
+	p.listType, p.list = listType, list
+	p.mu.Unlock()
+	return nil
+}
+
+// SetLimits sets how many connections a destination may open per minute and
+// how many it may have open at once. Zero means no limit.
+func (p *AccessPolicy) SetLimits(perMinute, concurrent int) {
+	p.mu.Lock()
+	p.perMinute, p.concurrent = perMinute, concurrent
+	p.mu.Unlock()
+}
+
+// Allowed reports whether the access list lets addr in, the limits are not
+// checked.
+func (p *AccessPolicy) Allowed(addr i2pkeys.I2PAddr) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.listed(addr.DestHash())
+}
+
+func (p *AccessPolicy) listed(h i2pkeys.I2PDestHash) bool {
+	switch p.listType {
+	case "whitelist":
+		return p.list[h]
+	case "blacklist":
+		return !p.list[h]
+	}
+	return true
+}
+
+// admit checks a new connection from addr. If it is let in, release has to be
+// called once it is closed.
+func (p *AccessPolicy) admit(addr i2pkeys.I2PAddr) (release func(), err error) {
+	h := addr.DestHash()
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.listed(h) {
+		return nil, ErrAccessDenied
+	}
+	if now.Sub(p.swept) > time.Minute {
+		p.sweep(now)
+	}
+	peer := p.peers[h]
+	if peer == nil {
+		peer = &peerAccess{}
+		p.peers[h] = peer
+	}
+	peer.forget(now)
+	// attempts count even when they are turned away, or a peer could keep
+	// hammering the listener at the limit
+	peer.recent = append(peer.recent, now)
+	if p.perMinute > 0 && len(peer.recent) > p.perMinute {
+		return nil, ErrRateLimited
+	}
+	if p.concurrent > 0 && peer.active >= p.concurrent {
+		return nil, ErrTooManyConns
+	}
+	peer.active++
+	return func() {
+		p.mu.Lock()
+		peer.active--
+		p.mu.Unlock()
+	}, nil
+}
+
+func (p *AccessPolicy) rejected(addr i2pkeys.I2PAddr, reason error) {
+	if p.OnReject != nil {
+		p.OnReject(addr, reason)
+	}
+}
+
+// drops destinations which have not connected for a minute
+func (p *AccessPolicy) sweep(now time.Time) {
+	for h, peer := range p.peers {
+		peer.forget(now)
+		if peer.active == 0 && len(peer.recent) == 0 {
+			delete(p.peers, h)
+		}
+	}
+	p.swept = now
+}
+
+// drops connection times older than a minute
+func (peer *peerAccess) forget(now time.Time) {
+	i := 0
+	for i < len(peer.recent) && now.Sub(peer.recent[i]) >= time.Minute {
+		i++
+	}
+	peer.recent = peer.recent[i:]
+}
+
+func readAccessList(fname string) ([]string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, sc.Err()
+}
+
+func parseAccessEntry(e string) (i2pkeys.I2PDestHash, error) {
+	e = strings.TrimSpace(e)
+	switch {
+	case strings.HasSuffix(e, ".b32.i2p"):
+		return i2pkeys.DestHashFromString(e)
+	case len(e) == 44:
+		b, err := i2pB64.DecodeString(e)
+		if err == nil {
+			return i2pkeys.DestHashFromBytes(b)
+		}
+	default:
+		if b, err := i2pB64.DecodeString(e); err == nil {
+			if _, err := parseDestination(b); err == nil {
+				return i2pkeys.I2PAddr(e).DestHash(), nil
+			}
+		}
+	}
+	return i2pkeys.I2PDestHash{}, errors.New("Invalid access list entry " + e)
+}
@@ -0,0 +1,193 @@
+package sam3
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+func newTestAddr(t *testing.T) i2pkeys.I2PAddr {
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys.Addr()
+}
+
+func Test_AccessPolicyList(t *testing.T) {
+	a, b, c, d := newTestAddr(t), newTestAddr(t), newTestAddr(t), newTestAddr(t)
+	hash := b.DestHash()
+	entries := []string{a.Base32(), i2pB64.EncodeToString(hash[:]), c.Base64()}
+
+	p, err := NewAccessPolicy("whitelist", entries...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []i2pkeys.I2PAddr{a, b, c} {
+		if !p.Allowed(addr) {
+			t.Errorf("whitelist turns away listed %s", addr.Base32())
+		}
+	}
+	if p.Allowed(d) {
+		t.Error("whitelist lets in an unlisted destination")
+	}
+
+	if err := p.SetList("blacklist", entries); err != nil {
+		t.Fatal(err)
+	}
+	if p.Allowed(a) || p.Allowed(b) || p.Allowed(c) {
+		t.Error("blacklist lets in a listed destination")
+	}
+	if !p.Allowed(d) {
+		t.Error("blacklist turns away an unlisted destination")
+	}
+
+	if err := p.SetList("", entries); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Allowed(a) || !p.Allowed(d) {
+		t.Error("policy without a list type turns destinations away")
+	}
+
+	if _, err := NewAccessPolicy("greylist"); err == nil {
+		t.Error("no error for an invalid list type")
+	}
+	if _, err := NewAccessPolicy("whitelist", "nonsense"); err == nil {
+		t.Error("no error for an invalid entry")
+	}
+}
+
+func Test_AccessPolicyLimits(t *testing.T) {
+	a, b := newTestAddr(t), newTestAddr(t)
+	p, err := NewAccessPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.SetLimits(0, 1)
+	release, err := p.admit(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.admit(a); !errors.Is(err, ErrTooManyConns) {
+		t.Errorf("second connection at once: %v, want ErrTooManyConns", err)
+	}
+	if _, err := p.admit(b); err != nil {
+		t.Errorf("other destination: %v", err)
+	}
+	release()
+	if _, err := p.admit(a); err != nil {
+		t.Errorf("connection after the first one closed: %v", err)
+	}
+
+	p.SetLimits(2, 0)
+	c := newTestAddr(t)
+	for i := 0; i < 2; i++ {
+		if _, err := p.admit(c); err != nil {
+			t.Fatalf("connection %d: %v", i+1, err)
+		}
+	}
+	if _, err := p.admit(c); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("third connection in a minute: %v, want ErrRateLimited", err)
+	}
+}
+
+func Test_AccessPolicyReload(t *testing.T) {
+	a, b := newTestAddr(t), newTestAddr(t)
+	fname := filepath.Join(t.TempDir(), "access.txt")
+	if err := os.WriteFile(fname, []byte("# friends\n\n"+a.Base32()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadAccessPolicy(fname, "whitelist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Allowed(a) || p.Allowed(b) {
+		t.Fatal("loaded whitelist does not match the file")
+	}
+
+	if err := os.WriteFile(fname, []byte(b.Base32()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Allowed(a) || !p.Allowed(b) {
+		t.Error("reloaded whitelist does not match the file")
+	}
+
+	if err := os.WriteFile(fname, []byte("nonsense\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err == nil {
+		t.Error("no error reloading an invalid entry")
+	}
+	if !p.Allowed(b) {
+		t.Error("failed reload dropped the current list")
+	}
+
+	p, err = NewAccessPolicy("whitelist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err == nil {
+		t.Error("no error reloading a policy without a file")
+	}
+}
+
+func Test_ListenerAccessPolicy(t *testing.T) {
+	fb, ss := newLifecycleSession(t)
+	defer ss.Close()
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, good := newTestAddr(t), newTestAddr(t)
+	p, err := NewAccessPolicy("blacklist", bad.Base32())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetLimits(0, 1)
+	var mu sync.Mutex
+	var rejected []error
+	p.OnReject = func(addr i2pkeys.I2PAddr, reason error) {
+		if addr.Base32() != bad.Base32() && addr.Base32() != good.Base32() {
+			t.Errorf("rejected unknown destination %s", addr.Base32())
+		}
+		mu.Lock()
+		rejected = append(rejected, reason)
+		mu.Unlock()
+	}
+	l.SetAccessPolicy(p)
+
+	fb.mu.Lock()
+	fb.peers = []i2pkeys.I2PAddr{bad, good, good}
+	fb.mu.Unlock()
+	first, err := l.AcceptI2P()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.RemoteAddr().(i2pkeys.I2PAddr).Base32() != good.Base32() {
+		t.Errorf("accepted %s, want the allowed destination", first.RemoteAddr())
+	}
+	// the second connection of good is turned away, a new destination is not
+	second, err := l.AcceptI2P()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RemoteAddr().(i2pkeys.I2PAddr).Base32() == good.Base32() {
+		t.Error("accepted a second connection at once")
+	}
+	first.Close()
+	second.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(rejected) != 2 || !errors.Is(rejected[0], ErrAccessDenied) || !errors.Is(rejected[1], ErrTooManyConns) {
+		t.Errorf("rejected with %v, want ErrAccessDenied and ErrTooManyConns", rejected)
+	}
+}
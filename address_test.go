@@ -0,0 +1,92 @@
+package sam3
+
+import (
+	"testing"
+)
+
+func Test_SplitSAMAddress(t *testing.T) {
+	tests := []struct {
+		addr, host, port string
+	}{
+		{"10.0.0.5:7656", "10.0.0.5", "7656"},
+		{"10.0.0.5", "10.0.0.5", SAM_PORT},
+		{"sam.example.org:7000", "sam.example.org", "7000"},
+		{"sam.example.org", "sam.example.org", SAM_PORT},
+		{"[::1]:7656", "::1", "7656"},
+		{"[fd00::5]", "fd00::5", SAM_PORT},
+		{"fd00::5", "fd00::5", SAM_PORT},
+		{":7657", SAM_HOST, "7657"},
+		{"tcp://10.0.0.5:7000", "10.0.0.5", "7000"},
+		{"tls://[::1]", "::1", SAM_PORT},
+	}
+	for _, tt := range tests {
+		host, port, err := splitSAMAddress(tt.addr)
+		if err != nil || host != tt.host || port != tt.port {
+			t.Errorf("splitSAMAddress(%q) = %q, %q, %v, want %q, %q", tt.addr, host, port, err, tt.host, tt.port)
+		}
+	}
+	for _, addr := range []string{"10.0.0.5:http", "10.0.0.5:70000", "fd00::5::x", "unix:///run/sam.sock"} {
+		if _, _, err := splitSAMAddress(addr); err == nil {
+			t.Errorf("splitSAMAddress(%q) did not fail", addr)
+		}
+	}
+}
+
+func Test_SetSAMAddress(t *testing.T) {
+	var cfg I2PConfig
+	cfg.SetSAMAddress("10.0.0.5:7000")
+	if cfg.Sam() != "10.0.0.5:7000" {
+		t.Errorf("I2PConfig.SetSAMAddress recorded %s", cfg.Sam())
+	}
+	cfg.SetSAMAddress("fd00::5")
+	if cfg.Sam() != "[fd00::5]:"+SAM_PORT {
+		t.Errorf("I2PConfig.SetSAMAddress recorded %s", cfg.Sam())
+	}
+	emit, err := NewEmit(SetSAMAddress("[fd00::5]:7000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emit.Sam() != "[fd00::5]:7000" {
+		t.Errorf("SetSAMAddress recorded %s", emit.Sam())
+	}
+}
+
+func Test_SecondaryConnectionsSameBridge(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:0", "[::1]:0"} {
+		b := newFakeBridge(t, "tcp", addr)
+		sam, err := NewSAM(b.l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := NewLocalKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ss, err := sam.NewStreamSession("secondary", keys, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := ss.DialI2P(keys.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		l, err := ss.Listen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err = l.AcceptI2P()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		ss.Close()
+		// one connection for the session, one for the dial, one for the accept
+		if n := b.count("HELLO VERSION"); n != 3 {
+			t.Errorf("%s: bridge got %d connections, want 3", b.l.Addr(), n)
+		}
+		if b.count("STREAM CONNECT") != 1 || b.count("STREAM ACCEPT") != 1 {
+			t.Errorf("%s: bridge got %v", b.l.Addr(), b.cmds)
+		}
+	}
+}
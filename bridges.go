@@ -0,0 +1,409 @@
+package sam3
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// Defaults of a BridgeSet
+const (
+	DefaultBridgeCheckInterval = 30 * time.Second
+	DefaultBridgeCheckTimeout  = 10 * time.Second
+)
+
+// how long a session waits at most between attempts to move to another bridge
+const maxFailoverBackoff = time.Minute
+
+// BridgeSet is a list of SAM bridges, usually of different routers, that
+// sessions can use interchangeably. New sessions go to a healthy bridge, and
+// a session whose bridge goes away is moved to another one with the same
+// name, keys and options, so its destination stays reachable.
+//
+// Bridges are preferred in the order they were added. If any of them has a
+// weight, the healthy ones are instead picked at random in proportion to
+// their weights.
+type BridgeSet struct {
+	// connects to the bridges added after it is set, DefaultDialer if nil
+	Dialer Dialer
+	// how often Watch checks the bridges, DefaultBridgeCheckInterval if zero
+	Interval time.Duration
+	// how long a check may take, DefaultBridgeCheckTimeout if zero
+	Timeout time.Duration
+	// OnFailover, if set, is called when a session was moved to another
+	// bridge or failed to. Otherwise that is logged.
+	OnFailover func(BridgeEvent)
+
+	mu      sync.Mutex
+	bridges []*bridge
+}
+
+// BridgeEvent tells a BridgeSet's OnFailover about a session that lost its
+// bridge.
+type BridgeEvent struct {
+	Session *StreamSession
+	// the address of the bridge the session lost, and of the one it moved
+	// to, empty if it did not
+	From, To string
+	// why the session could not be moved, nil if it was
+	Err error
+}
+
+// a bridge of a BridgeSet, guarded by its mu
+type bridge struct {
+	addr    string
+	weight  int
+	t       *samTransport
+	healthy bool
+	err     error
+}
+
+// NewBridgeSet returns a BridgeSet of the bridges at addrs, in order of
+// preference. See NewSAM for the address forms.
+func NewBridgeSet(addrs ...string) (*BridgeSet, error) {
+	b := &BridgeSet{}
+	for _, addr := range addrs {
+		if err := b.Add(addr, 0); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Add adds the bridge at addr. It is thought healthy until a check or a
+// connection to it fails.
+func (b *BridgeSet) Add(addr string, weight int) error {
+	if weight < 0 {
+		return errors.New("Negative weight for SAM bridge " + addr)
+	}
+	t, err := parseSAMAddress(addr, b.Dialer)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.bridges = append(b.bridges, &bridge{addr: addr, weight: weight, t: t, healthy: true})
+	b.mu.Unlock()
+	return nil
+}
+
+// Healthy returns the addresses of the bridges that passed their last check.
+func (b *BridgeSet) Healthy() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var addrs []string
+	for _, br := range b.bridges {
+		if br.healthy {
+			addrs = append(addrs, br.addr)
+		}
+	}
+	return addrs
+}
+
+// Check checks all bridges now. A bridge is healthy if it answers HELLO and
+// then PING within the Timeout. It returns an error if none is.
+func (b *BridgeSet) Check(ctx context.Context) error {
+	b.mu.Lock()
+	bridges := append([]*bridge(nil), b.bridges...)
+	b.mu.Unlock()
+	var wg sync.WaitGroup
+	errs := make([]error, len(bridges))
+	for i, br := range bridges {
+		wg.Add(1)
+		go func(i int, br *bridge) {
+			defer wg.Done()
+			errs[i] = b.check(ctx, br)
+			b.mark(br.addr, errs[i])
+		}(i, br)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	if len(errs) == 0 {
+		return errors.New("No SAM bridges")
+	}
+	return errors.New("No healthy SAM bridge, " + errs[0].Error())
+}
+
+// Watch checks the bridges every Interval until ctx is done.
+func (b *BridgeSet) Watch(ctx context.Context) {
+	interval := b.Interval
+	if interval <= 0 {
+		interval = DefaultBridgeCheckInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		b.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (b *BridgeSet) check(ctx context.Context, br *bridge) error {
+	timeout := b.Timeout
+	if timeout <= 0 {
+		timeout = DefaultBridgeCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	sam, err := dialSAM(ctx, br.addr, br.t)
+	if err != nil {
+		return err
+	}
+	defer sam.Close()
+	stop := watchContext(ctx, sam.conn)
+	_, err = io.WriteString(sam.conn, "PING health\n")
+	var line string
+	if err == nil {
+		line, err = bufio.NewReader(sam.conn).ReadString('\n')
+	}
+	if cerr := stop(); cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "PONG") {
+		return errors.New("SAM bridge answered PING with " + strings.TrimSpace(line))
+	}
+	return nil
+}
+
+// records how the last check or connection to the bridge at addr went
+func (b *BridgeSet) mark(addr string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, br := range b.bridges {
+		if br.addr == addr {
+			br.healthy, br.err = err == nil, err
+		}
+	}
+}
+
+// returns the bridges in the order to try them, the unhealthy ones last
+func (b *BridgeSet) order() []*bridge {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var healthy, unhealthy []*bridge
+	weighted := false
+	for _, br := range b.bridges {
+		if br.healthy {
+			healthy = append(healthy, br)
+		} else {
+			unhealthy = append(unhealthy, br)
+		}
+		weighted = weighted || br.weight > 0
+	}
+	if weighted {
+		healthy = weightedShuffle(healthy)
+	}
+	return append(healthy, unhealthy...)
+}
+
+// orders bridges at random, the ones with more weight more likely first
+func weightedShuffle(bridges []*bridge) []*bridge {
+	var out []*bridge
+	for len(bridges) > 0 {
+		total := 0
+		for _, br := range bridges {
+			total += br.weight
+		}
+		i := 0
+		if total > 0 {
+			n := rand.Intn(total)
+			for n >= bridges[i].weight {
+				n -= bridges[i].weight
+				i++
+			}
+		}
+		out = append(out, bridges[i])
+		bridges = append(bridges[:i:i], bridges[i+1:]...)
+	}
+	return out
+}
+
+// NewSAM connects to the first bridge that answers, healthy ones first.
+func (b *BridgeSet) NewSAM(ctx context.Context) (*SAM, error) {
+	bridges := b.order()
+	if len(bridges) == 0 {
+		return nil, errors.New("No SAM bridges")
+	}
+	var first error
+	for _, br := range bridges {
+		sam, err := dialSAM(ctx, br.addr, br.t)
+		b.mark(br.addr, err)
+		if err == nil {
+			return sam, nil
+		}
+		if first == nil {
+			first = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, first
+}
+
+// NewStreamSession creates a StreamSession on a healthy bridge, see
+// SAM.NewStreamSession. If the bridge goes away the session is moved to
+// another one, until it is closed.
+func (b *BridgeSet) NewStreamSession(id string, keys i2pkeys.I2PKeys, options []string) (*StreamSession, error) {
+	sam, err := b.NewSAM(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	ss, err := sam.NewStreamSession(id, keys, options)
+	if err != nil {
+		sam.Close()
+		return nil, err
+	}
+	go b.keep(ss)
+	return ss, nil
+}
+
+// watches the bridge of ss and moves it when the bridge goes away
+func (b *BridgeSet) keep(ss *StreamSession) {
+	for {
+		conn := ss.control()
+		err := serveControl(conn)
+		// wait for a rekey in progress, which closed the old connection
+		ss.renew.Lock()
+		ss.renew.Unlock()
+		select {
+		case <-ss.stop:
+			return
+		default:
+		}
+		if ss.control() != conn {
+			// the session was re-created, with new offline keys
+			continue
+		}
+		from := ss.bridgeAddr()
+		b.mark(from, err)
+		backoff := time.Second
+		for {
+			sam, err := b.NewSAM(context.Background())
+			if err == nil {
+				err = ss.moveTo(sam)
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			ev := BridgeEvent{Session: ss, From: from, Err: err}
+			if err == nil {
+				ev.To = ss.bridgeAddr()
+			}
+			b.failover(ev)
+			if err == nil {
+				break
+			}
+			t := time.NewTimer(backoff)
+			select {
+			case <-ss.stop:
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			if backoff *= 2; backoff > maxFailoverBackoff {
+				backoff = maxFailoverBackoff
+			}
+		}
+	}
+}
+
+func (b *BridgeSet) failover(ev BridgeEvent) {
+	if b.OnFailover != nil {
+		b.OnFailover(ev)
+		return
+	}
+	if ev.Err != nil {
+		log.Printf("sam3: moving session %s away from SAM bridge %s failed: %s", ev.Session.ID(), ev.From, ev.Err)
+	} else {
+		log.Printf("sam3: moved session %s from SAM bridge %s to %s", ev.Session.ID(), ev.From, ev.To)
+	}
+}
+
+// serveControl answers the PINGs of the bridge on the control connection of
+// a session until it breaks.
+func serveControl(conn net.Conn) error {
+	rd := bufio.NewReader(conn)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "PING") {
+			if _, err := io.WriteString(conn, "PONG"+strings.TrimPrefix(line, "PING")); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// the control connection of the session
+func (ss *StreamSession) control() net.Conn {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.conn
+}
+
+// the address of the bridge the session is on
+func (ss *StreamSession) bridgeAddr() string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.samAddr
+}
+
+// re-creates the session on the bridge sam is connected to, with the same
+// name, keys and options. The SESSION CREATE can take minutes, so it runs
+// without holding mu, and closing the session interrupts it.
+func (ss *StreamSession) moveTo(sam *SAM) error {
+	ss.renew.Lock()
+	defer ss.renew.Unlock()
+	if err := ss.track(sam.conn); err != nil {
+		sam.Close()
+		return err
+	}
+	defer ss.untrack(sam.conn)
+	ss.mu.Lock()
+	id, from, to, keys, sigType, options := ss.id, ss.from, ss.to, ss.keys, ss.sigType, ss.options
+	ss.mu.Unlock()
+	conn, _, err := sam.createSession(context.Background(), "STREAM", id, from, to, keys, sigType, options, []string{})
+	if err != nil {
+		sam.Close()
+		return ss.closedErr(err)
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	select {
+	case <-ss.stop:
+		conn.Close()
+		return net.ErrClosed
+	default:
+	}
+	ss.conn.Close()
+	ss.conn = conn
+	ss.samAddr, ss.bridge = sam.address, sam.sessionTransport()
+	if ss.resolver != nil {
+		// it is connected to the old bridge
+		ss.resolver.Close()
+		ss.resolver = nil
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package sam3
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_BridgeSetFailover(t *testing.T) {
+	b1 := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	b2 := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	addr1, addr2 := b1.l.Addr().String(), b2.l.Addr().String()
+	set, err := NewBridgeSet(addr1, addr2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Timeout = time.Second
+	events := make(chan BridgeEvent, 10)
+	set.OnFailover = func(ev BridgeEvent) { events <- ev }
+	ctx := context.Background()
+
+	if err := set.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if h := set.Healthy(); len(h) != 2 {
+		t.Fatalf("healthy bridges %v, want both", h)
+	}
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := set.NewStreamSession("", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if n := b1.count("SESSION CREATE"); n != 1 {
+		t.Fatalf("first bridge got %d SESSION CREATEs, want 1", n)
+	}
+
+	b1.shutdown()
+	select {
+	case ev := <-events:
+		if ev.Session != ss || ev.From != addr1 || ev.To != addr2 || ev.Err != nil {
+			t.Errorf("failover %+v, want from %s to %s", ev, addr1, addr2)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not moved")
+	}
+	if n := b2.count("SESSION CREATE"); n != 1 {
+		t.Errorf("second bridge got %d SESSION CREATEs, want 1", n)
+	}
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := b2.count("STREAM CONNECT"); n != 1 {
+		t.Errorf("second bridge got %d STREAM CONNECTs, want 1", n)
+	}
+
+	if err := set.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if h := set.Healthy(); len(h) != 1 || h[0] != addr2 {
+		t.Errorf("healthy bridges %v, want %s", h, addr2)
+	}
+
+	b2.shutdown()
+	select {
+	case ev := <-events:
+		if ev.From != addr2 || ev.To != "" || ev.Err == nil {
+			t.Errorf("failover without bridges %+v, want an error", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed failover was not reported")
+	}
+	if err := set.Check(ctx); err == nil {
+		t.Error("Check passed without bridges")
+	}
+}
+
+func Test_WeightedShuffle(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		out := weightedShuffle([]*bridge{{addr: "a"}, {addr: "b", weight: 3}, {addr: "c", weight: 1}})
+		if len(out) != 3 || out[0].addr == "a" || out[1].addr == "a" || out[2].addr != "a" {
+			t.Fatalf("bridge without weight picked before the others")
+		}
+	}
+}
+
+func Test_BridgeSetStalledFailover(t *testing.T) {
+	b1 := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	b2 := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	set, err := NewBridgeSet(b1.l.Addr().String(), b2.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.OnFailover = func(ev BridgeEvent) {}
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := set.NewStreamSession("", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the other bridge can not build tunnels, the session waits for it
+	b2.mu.Lock()
+	b2.stall = true
+	b2.mu.Unlock()
+	b1.shutdown()
+	for deadline := time.Now().Add(5 * time.Second); b2.count("SESSION CREATE") == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("session was not moved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ss.Keys()
+		ss.Addr()
+		ss.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("session blocked while it was moved")
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		b2.mu.Lock()
+		dropped := b2.dropped
+		b2.mu.Unlock()
+		if dropped == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Close did not end the SESSION CREATE")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
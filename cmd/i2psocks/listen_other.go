@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package main
+
+import "net"
+
+func listenTCP(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
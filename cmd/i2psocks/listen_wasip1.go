@@ -0,0 +1,13 @@
+//go:build wasip1
+
+package main
+
+import (
+	"net"
+
+	"github.com/stealthrocket/net/wasip1"
+)
+
+func listenTCP(addr string) (net.Listener, error) {
+	return wasip1.Listen("tcp", addr)
+}
@@ -0,0 +1,48 @@
+// Command i2psocks is a SOCKS5 proxy to I2P sites.
+//
+//	i2psocks -listen 127.0.0.1:1080 -user me -pass secret
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	sam3 "github.com/ivobilic/waSAM"
+	sam "github.com/ivobilic/waSAM/helper"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:1080", "address to accept SOCKS clients on")
+	samaddr := flag.String("sam", "", "SAM bridge address, $sam_host:$sam_port if empty")
+	name := flag.String("name", "i2psocks", "SAM tunnel name")
+	keys := flag.String("keys", "", "keep the client destination in this file, throwaway if empty")
+	user := flag.String("user", "", "username clients have to log in with")
+	pass := flag.String("pass", "", "password clients have to log in with")
+	outproxy := flag.String("outproxy", "", "SOCKS5 outproxy .i2p host for other sites")
+	idle := flag.Duration("idle", 10*time.Minute, "close connections idle for this long, 0 to keep them")
+	flag.Parse()
+
+	session, err := sam.Dialer(*name, *samaddr, *keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := sam3.NewSOCKSServer(session)
+	s.Username, s.Password = *user, *pass
+	s.Outproxy = *outproxy
+	s.IdleTimeout = *idle
+	err = run(s, *listen)
+	// log.Fatal skips deferred calls, the router should not keep the tunnels
+	session.Close()
+	log.Fatal(err)
+}
+
+// serves SOCKS clients on listen until that fails
+func run(s *sam3.SOCKSServer, listen string) error {
+	l, err := listenTCP(listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("SOCKS5 proxy to I2P on %s", l.Addr())
+	return s.Serve(l)
+}
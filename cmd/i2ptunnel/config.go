@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	sam3 "github.com/ivobilic/waSAM"
+)
+
+// tunnel is one section of the tunnels file, in the format of the i2pd
+// tunnels.conf:
+//
+//	[eepsite]
+//	type = server
+//	host = 127.0.0.1
+//	port = 8080
+//	keys = eepsite.keys
+//	accesslist = friend1.b32.i2p, friend2.b32.i2p
+//
+//	[irc]
+//	type = client
+//	address = 127.0.0.1
+//	port = 6668
+//	destination = irc.postman.i2p
+//
+// Keys starting with inbound., outbound., i2cp. or i2p.streaming. are passed
+// to the router as session options.
+type tunnel struct {
+	Name string
+	Type string
+	// local side: where a client tunnel listens, where a server tunnel
+	// forwards to
+	Host string
+	Port int
+	// I2P side of client tunnels
+	Destination string
+	// file with persistent keys, throwaway keys if empty
+	Keys string
+	// destinations allowed to connect to a server tunnel, everyone if empty
+	AccessList []string
+	Options    []string
+}
+
+// the address of the local side
+func (t *tunnel) local() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+func parseTunnels(r io.Reader) ([]*tunnel, error) {
+	var tunnels []*tunnel
+	var t *tunnel
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			t = &tunnel{Name: strings.TrimSpace(line[1 : len(line)-1]), Host: "127.0.0.1"}
+			tunnels = append(tunnels, t)
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || t == nil {
+			return nil, fmt.Errorf("line %d: expected key = value in a [tunnel] section", n)
+		}
+		k, v := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+		var err error
+		switch {
+		case k == "type":
+			t.Type = strings.ToLower(v)
+		case k == "host" || k == "address":
+			t.Host = v
+		case k == "port":
+			t.Port, err = strconv.Atoi(v)
+		case k == "destination":
+			t.Destination = v
+		case k == "keys":
+			t.Keys = v
+		case k == "accesslist":
+			for _, a := range strings.Split(v, ",") {
+				if a = strings.TrimSpace(a); a != "" {
+					t.AccessList = append(t.AccessList, a)
+				}
+			}
+		case strings.HasPrefix(k, "inbound."), strings.HasPrefix(k, "outbound."),
+			strings.HasPrefix(k, "i2cp."), strings.HasPrefix(k, "i2p.streaming."):
+			t.Options = append(t.Options, strings.TrimSpace(kv[0])+"="+v)
+		default:
+			err = fmt.Errorf("unknown key %s", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for _, t := range tunnels {
+		if err := t.check(); err != nil {
+			return nil, err
+		}
+	}
+	return tunnels, nil
+}
+
+func (t *tunnel) check() error {
+	switch t.Type {
+	case "client":
+		if t.Destination == "" {
+			return fmt.Errorf("[%s]: client tunnel without destination", t.Name)
+		}
+	case "server":
+		if _, err := sam3.NewAccessPolicy("whitelist", t.AccessList...); err != nil {
+			return fmt.Errorf("[%s]: %s", t.Name, err)
+		}
+	default:
+		return fmt.Errorf("[%s]: type must be client or server, not %q", t.Name, t.Type)
+	}
+	if t.Port < 1 || t.Port > 65535 {
+		return fmt.Errorf("[%s]: invalid port %d", t.Name, t.Port)
+	}
+	return nil
+}
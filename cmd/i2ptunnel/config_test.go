@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ParseTunnels(t *testing.T) {
+	conf := `
+# comment
+; also a comment
+[eepsite]
+type = Server
+host = ::1
+port = 8080
+keys = eepsite.keys
+inbound.length = 2
+i2p.streaming.maxConnsPerMinute = 10
+
+[irc]
+type = client
+address = 0.0.0.0
+port = 6668
+destination = irc.postman.i2p
+`
+	tunnels, err := parseTunnels(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*tunnel{
+		{Name: "eepsite", Type: "server", Host: "::1", Port: 8080, Keys: "eepsite.keys",
+			Options: []string{"inbound.length=2", "i2p.streaming.maxConnsPerMinute=10"}},
+		{Name: "irc", Type: "client", Host: "0.0.0.0", Port: 6668, Destination: "irc.postman.i2p"},
+	}
+	if !reflect.DeepEqual(tunnels, want) {
+		t.Errorf("parseTunnels = %+v, want %+v", tunnels, want)
+	}
+	if l := tunnels[0].local(); l != "[::1]:8080" {
+		t.Errorf("local address %s, want [::1]:8080", l)
+	}
+	if l := tunnels[1].local(); l != "0.0.0.0:6668" {
+		t.Errorf("local address %s, want 0.0.0.0:6668", l)
+	}
+}
+
+func Test_ParseTunnelsErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, conf, err string
+	}{
+		{"no section", "type = client\n", "line 1"},
+		{"no value", "[a]\ntype\n", "line 2"},
+		{"bad port", "[a]\ntype = client\nport = x\n", "line 3"},
+		{"unknown key", "[a]\ncolor = red\n", "unknown key color"},
+		{"bad type", "[a]\ntype = proxy\nport = 1\n", "type must be client or server"},
+		{"no destination", "[a]\ntype = client\nport = 1\n", "without destination"},
+		{"bad access list", "[a]\ntype = server\nport = 1\naccesslist = nonsense\n", "Invalid access list entry"},
+		{"port out of range", "[a]\ntype = server\nport = 70000\n", "invalid port"},
+		{"no port", "[a]\ntype = client\ndestination = x.i2p\n", "invalid port"},
+	} {
+		_, err := parseTunnels(strings.NewReader(tt.conf))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: %v, want an error with %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func Test_Check(t *testing.T) {
+	for _, tt := range []struct {
+		t  tunnel
+		ok bool
+	}{
+		{tunnel{Type: "client", Destination: "x.i2p", Port: 1}, true},
+		{tunnel{Type: "client", Port: 1}, false},
+		{tunnel{Type: "server", Port: 65535}, true},
+		{tunnel{Type: "server", Port: 0}, false},
+		{tunnel{Type: "server", Port: 80, AccessList: []string{"bad"}}, false},
+		{tunnel{Type: "", Port: 80}, false},
+	} {
+		if err := tt.t.check(); (err == nil) != tt.ok {
+			t.Errorf("check of %+v: %v", tt.t, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Command i2ptunnel runs client and server tunnels between local TCP ports
+// and I2P, like the i2ptunnel of the Java router. Client tunnels forward a
+// local port to an I2P destination, server tunnels forward an I2P destination
+// to a local host:port. The tunnels are read from a file, see config.go for
+// its format, and each one is restarted on its own when it fails.
+//
+//	i2ptunnel -sam 127.0.0.1:7656 -conf tunnels.conf
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+	sam3 "github.com/ivobilic/waSAM"
+)
+
+// how long to wait before restarting a failed tunnel, doubled up to
+// maxBackoff while it keeps failing
+const (
+	minBackoff = 5 * time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// connections are closed after being idle for this long
+var idle time.Duration
+
+func main() {
+	samaddr := flag.String("sam", "", "SAM bridge address, $sam_host:$sam_port if empty")
+	conf := flag.String("conf", "tunnels.conf", "file with the tunnel definitions")
+	flag.DurationVar(&idle, "idle", 10*time.Minute, "close connections idle for this long, 0 to keep them")
+	flag.Parse()
+
+	f, err := os.Open(*conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tunnels, err := parseTunnels(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %s", *conf, err)
+	}
+	if len(tunnels) == 0 {
+		log.Fatalf("%s: no tunnels", *conf)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, t := range tunnels {
+		wg.Add(1)
+		go func(t *tunnel) {
+			defer wg.Done()
+			supervise(ctx, sam3.SAMDefaultAddr(*samaddr), t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+// runs t until ctx is done, restarting it when it fails
+func supervise(ctx context.Context, samaddr string, t *tunnel) {
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		err := run(ctx, samaddr, t)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		log.Printf("[%s] failed: %s, restarting in %s", t.Name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// runs t once, until it fails or ctx is done
+func run(ctx context.Context, samaddr string, t *tunnel) error {
+	keys, err := sam3.EnsureKeyfile(t.Keys)
+	if err != nil {
+		return err
+	}
+	sam, err := sam3.NewSAM(samaddr)
+	if err != nil {
+		return err
+	}
+	log.Printf("[%s] building tunnels for %s", t.Name, keys.Addr().Base32())
+	session, err := sam.NewStreamSession(t.Name, keys, t.Options)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if t.Type == "client" {
+		return runClient(ctx, session, t)
+	}
+	return runServer(ctx, session, t)
+}
+
+// forwards connections to the local port to the destination
+func runClient(ctx context.Context, session *sam3.StreamSession, t *tunnel) error {
+	l, err := listenTCP(t.local())
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-session.Done():
+		}
+		l.Close()
+	}()
+	log.Printf("[%s] forwarding %s to %s", t.Name, l.Addr(), t.Destination)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			select {
+			case <-session.Done():
+				return errors.New("session closed")
+			default:
+			}
+			return err
+		}
+		go func() {
+			defer c.Close()
+			remote, err := session.DialContext(ctx, "tcp", t.Destination)
+			if err != nil {
+				log.Printf("[%s] %s: %s", t.Name, t.Destination, err)
+				if sessionGone(err) && ctx.Err() == nil {
+					// let the tunnel be restarted
+					session.Close()
+				}
+				return
+			}
+			defer remote.Close()
+			sam3.Pipe(c, remote, idle)
+		}()
+	}
+}
+
+// reports whether a dial failed because the session is gone, with the bridge
+// or dropped by the router
+func sessionGone(err error) bool {
+	var oerr *net.OpError
+	return errors.As(err, &oerr) && oerr.Op == "dial" || errors.Is(err, sam3.ErrInvalidID)
+}
+
+// forwards connections to the session to the local host:port
+func runServer(ctx context.Context, session *sam3.StreamSession, t *tunnel) error {
+	l, err := session.Listen()
+	if err != nil {
+		return err
+	}
+	if len(t.AccessList) > 0 {
+		p, err := sam3.NewAccessPolicy("whitelist", t.AccessList...)
+		if err != nil {
+			return err
+		}
+		p.OnReject = func(addr i2pkeys.I2PAddr, reason error) {
+			log.Printf("[%s] %s: %s", t.Name, addr.Base32(), reason)
+		}
+		l.SetAccessPolicy(p)
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	log.Printf("[%s] forwarding %s to %s", t.Name, session.Addr().Base32(), t.local())
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer c.Close()
+			local, err := dialTCP(t.local())
+			if err != nil {
+				log.Printf("[%s] %s: %s", t.Name, t.local(), err)
+				return
+			}
+			defer local.Close()
+			sam3.Pipe(c, local, idle)
+		}()
+	}
+}
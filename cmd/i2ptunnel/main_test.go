@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	sam3 "github.com/ivobilic/waSAM"
+)
+
+func Test_SessionGone(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		gone bool
+	}{
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{sam3.ErrInvalidID, true},
+		{fmt.Errorf("dial: %w", sam3.ErrInvalidID), true},
+		{sam3.ErrCantReachPeer, false},
+		{&net.OpError{Op: "read", Net: "tcp", Err: io.EOF}, false},
+		{errors.New("other"), false},
+	} {
+		if sessionGone(tt.err) != tt.gone {
+			t.Errorf("sessionGone(%v) = %v, want %v", tt.err, !tt.gone, tt.gone)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !wasip1
+
+package main
+
+import "net"
+
+func listenTCP(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func dialTCP(addr string) (net.Conn, error) {
+	return net.Dial("tcp", addr)
+}
@@ -0,0 +1,140 @@
+// Command samkeys works with I2P destination keys in the format
+// EnsureKeyfile reads and writes.
+//
+//	samkeys keygen -sig ECDSA_SHA256_P256 -out service.keys
+//	samkeys keygen -prefix abc -out service.keys
+//	samkeys offline -master master.keys -out server.keys -expires 720h
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+	sam3 "github.com/ivobilic/waSAM"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  keygen    generate keys, optionally with a vanity .b32.i2p prefix\n")
+	fmt.Fprintf(os.Stderr, "  offline   sign a transient key with master keys kept offline\n")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "keygen":
+		err = keygen(os.Args[2:])
+	case "offline":
+		err = offline(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func keygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "wanted start of the .b32.i2p address")
+	out := fs.String("out", "", "file to write the keys to, stdout if empty")
+	workers := fs.Int("workers", 0, "goroutines to search with, all CPUs if 0")
+	sigType := fs.String("sig", sam3.Sig_EdDSA_SHA512_Ed25519, "signature type, vanity keys are always EdDSA")
+	cryptoType := fs.String("crypto", sam3.Crypto_ELGAMAL, "encryption type of the destination")
+	fs.Parse(args)
+	if *prefix == "" {
+		keys, err := sam3.NewLocalKeysWithCrypto(*sigType, *cryptoType)
+		if err != nil {
+			return err
+		}
+		if err := writeKeys(keys, *out); err != nil {
+			return err
+		}
+		log.Printf("generated %s", keys.Addr().Base32())
+		return nil
+	}
+	if !isEdDSA(*sigType) {
+		return errors.New("vanity keys are always EdDSA_SHA512_Ed25519, leave out -sig " + *sigType)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	keys, err := sam3.NewVanityKeysWithCrypto(ctx, *prefix, *cryptoType, *workers, func(p sam3.VanityProgress) {
+		rate := float64(p.Tried) / p.Elapsed.Seconds()
+		log.Printf("tried %d (%.0f/s), about %s on average", p.Tried, rate, time.Duration(p.Expected/rate*float64(time.Second)).Round(time.Second))
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeKeys(keys, *out); err != nil {
+		return err
+	}
+	log.Printf("found %s", keys.Addr().Base32())
+	return nil
+}
+
+// reports whether the -sig flag names EdDSA_SHA512_Ed25519, in any form
+// the library accepts
+func isEdDSA(sigType string) bool {
+	s := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(sigType), "SIGNATURE_TYPE="))
+	return s == "" || s == "7" || strings.EqualFold(s, "EdDSA_SHA512_Ed25519")
+}
+
+func offline(args []string) error {
+	fs := flag.NewFlagSet("offline", flag.ExitOnError)
+	master := fs.String("master", "", "file with the master keys")
+	out := fs.String("out", "", "file to write the offline-signed keys to, stdout if empty")
+	expires := fs.Duration("expires", 30*24*time.Hour, "how long the transient key is valid")
+	sigType := fs.String("sig", sam3.Sig_EdDSA_SHA512_Ed25519, "signature type of the transient key")
+	fs.Parse(args)
+	if *master == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(*master)
+	if err != nil {
+		return err
+	}
+	keys, err := i2pkeys.LoadKeysIncompat(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	okeys, err := sam3.NewOfflineKeys(keys, time.Now().Add(*expires), *sigType)
+	if err != nil {
+		return err
+	}
+	if err := writeKeys(okeys, *out); err != nil {
+		return err
+	}
+	until, _ := sam3.OfflineExpires(okeys)
+	log.Printf("%s valid until %s", okeys.Addr().Base32(), until)
+	return nil
+}
+
+// writes keys in the format EnsureKeyfile reads, to stdout if fname is empty
+func writeKeys(keys i2pkeys.I2PKeys, fname string) error {
+	var w io.Writer = os.Stdout
+	if fname != "" {
+		f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return i2pkeys.StoreKeysIncompat(keys, w)
+}
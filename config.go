@@ -2,7 +2,7 @@ package sam3
 
 import (
 	"fmt"
-	"math/rand"
+	"net"
 	"strconv"
 	"strings"
 
@@ -14,6 +14,8 @@ type I2PConfig struct {
 	SamHost string
 	SamPort string
 	TunName string
+	// generated tunnel names start with it, see NewID
+	IDPrefix string
 
 	SamMin string
 	SamMax string
@@ -55,6 +57,18 @@ type I2PConfig struct {
 	//Streaming Library options
 	AccessListType string
 	AccessList     []string
+
+	// bytes per second a session reads and writes over all its connections,
+	// and each connection on its own. These are enforced by the library, not
+	// the router. Empty or 0 means no limit.
+	BandwidthIn      string
+	BandwidthOut     string
+	BandwidthInConn  string
+	BandwidthOutConn string
+
+	// connects the sessions made after it is set to the SAM bridge,
+	// DefaultDialer if nil
+	Dialer Dialer
 }
 
 func (f *I2PConfig) Sam() string {
@@ -66,28 +80,23 @@ func (f *I2PConfig) Sam() string {
 	if f.SamPort != "" {
 		port = f.SamPort
 	}
-	return host + ":" + port
+	return net.JoinHostPort(host, port)
 }
 
+// SetSAMAddress sets SamHost and SamPort from a SAM address, see NewSAM for
+// its forms. The default host and port fill in what is left out. Addresses
+// without a host and port, like unix:// ones, leave both unchanged.
 func (f *I2PConfig) SetSAMAddress(addr string) {
-	hp := strings.Split(addr, ":")
-	if len(hp) == 1 {
-		f.SamHost = hp[0]
-	} else if len(hp) == 2 {
-		f.SamPort = hp[1]
-		f.SamHost = hp[0]
-	}
-	f.SamPort = "7656"
-	f.SamHost = "127.0.0.1"
+	host, port, err := splitSAMAddress(addr)
+	if err != nil {
+		return
+	}
+	f.SamHost, f.SamPort = host, port
 }
 
 func (f *I2PConfig) ID() string {
 	if f.TunName == "" {
-		b := make([]byte, 12)
-		for i := range b {
-			b[i] = "abcdefghijklmnopqrstuvwxyz"[rand.Intn(len("abcdefghijklmnopqrstuvwxyz"))]
-		}
-		f.TunName = string(b)
+		f.TunName = NewID(f.IDPrefix)
 	}
 	return " ID=" + f.TunName + " "
 }
@@ -150,18 +159,29 @@ func (f *I2PConfig) MinSAM() string {
 
 func (f *I2PConfig) MaxSAM() string {
 	if f.SamMax == "" {
-		return "3.1"
+		// 3.3 for offline-signed keys, routers answer with the highest
+		// version they support
+		return "3.3"
 	}
 	return f.SamMax
 }
 
 func (f *I2PConfig) DestinationKey() string {
-	if &f.DestinationKeys != nil {
+	if f.DestinationKeys.String() != "" {
 		return " DESTINATION=" + f.DestinationKeys.String() + " "
 	}
 	return " DESTINATION=TRANSIENT "
 }
 
+// bandwidth returns one of the Bandwidth* limits in bytes per second
+func (f *I2PConfig) bandwidth(s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0
+	}
+	return i
+}
+
 func (f *I2PConfig) SignatureType() string {
 	if f.samMax() < 3.1 {
 		return ""
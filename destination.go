@@ -0,0 +1,442 @@
+package sam3
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// I2Ps own base64 alphabet, used for destinations and private keys.
+var i2pB64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+const (
+	certNull = 0
+	certKey  = 5
+
+	// the public key, padding and signing key of a destination always add up
+	// to this many bytes, the certificate follows
+	keysAndCertLen  = 384
+	cryptoKeyField  = 256
+	signingKeyField = 128
+)
+
+// describes one of I2Ps signature types
+type sigSpec struct {
+	name    string
+	code    int
+	pubLen  int
+	privLen int
+	sigLen  int
+}
+
+var sigSpecs = []sigSpec{
+	{"DSA_SHA1", 0, 128, 20, 40},
+	{"ECDSA_SHA256_P256", 1, 64, 32, 64},
+	{"ECDSA_SHA384_P384", 2, 96, 48, 96},
+	{"ECDSA_SHA512_P521", 3, 132, 66, 132},
+	{"EdDSA_SHA512_Ed25519", 7, 32, 32, 64},
+}
+
+// describes one of I2Ps encryption types
+type cryptoSpec struct {
+	name    string
+	code    int
+	pubLen  int
+	privLen int
+}
+
+var cryptoSpecs = []cryptoSpec{
+	{"ELGAMAL", 0, 256, 256},
+	{"ECIES_X25519", 4, 32, 32},
+}
+
+func lookupSigSpec(code int) (sigSpec, error) {
+	for _, s := range sigSpecs {
+		if s.code == code {
+			return s, nil
+		}
+	}
+	return sigSpec{}, fmt.Errorf("Unsupported signature type %d", code)
+}
+
+func lookupCryptoSpec(code int) (cryptoSpec, error) {
+	for _, c := range cryptoSpecs {
+		if c.code == code {
+			return c, nil
+		}
+	}
+	return cryptoSpec{}, fmt.Errorf("Unsupported encryption type %d", code)
+}
+
+// parseSigType accepts one of the Sig_* constants, a bare signature type name
+// or its number. The empty string and Sig_NONE mean EdDSA_SHA512_Ed25519.
+func parseSigType(s string) (sigSpec, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "SIGNATURE_TYPE="))
+	if s == "" {
+		return lookupSigSpec(7)
+	}
+	if code, err := strconv.Atoi(s); err == nil {
+		return lookupSigSpec(code)
+	}
+	for _, spec := range sigSpecs {
+		if strings.EqualFold(spec.name, s) {
+			return spec, nil
+		}
+	}
+	return sigSpec{}, errors.New("Unknown signature type: " + s)
+}
+
+// the parsed layout of a destination
+type destination struct {
+	raw    []byte
+	sig    sigSpec
+	crypto cryptoSpec
+	encPub []byte
+	sigPub []byte
+}
+
+// parseDestination reads a destination from the start of b, anything after it
+// is ignored.
+func parseDestination(b []byte) (*destination, error) {
+	if len(b) < keysAndCertLen+3 {
+		return nil, errors.New("Destination too short")
+	}
+	d := &destination{}
+	certType := b[keysAndCertLen]
+	certLen := int(binary.BigEndian.Uint16(b[keysAndCertLen+1:]))
+	end := keysAndCertLen + 3 + certLen
+	if len(b) < end {
+		return nil, errors.New("Destination certificate truncated")
+	}
+	d.raw = b[:end]
+	var err error
+	switch certType {
+	case certNull:
+		d.sig, _ = lookupSigSpec(0)
+		d.crypto, _ = lookupCryptoSpec(0)
+		d.encPub = b[:cryptoKeyField]
+		d.sigPub = b[cryptoKeyField:keysAndCertLen]
+		return d, nil
+	case certKey:
+		if certLen < 4 {
+			return nil, errors.New("Key certificate too short")
+		}
+		payload := b[keysAndCertLen+3 : end]
+		if d.sig, err = lookupSigSpec(int(binary.BigEndian.Uint16(payload))); err != nil {
+			return nil, err
+		}
+		if d.crypto, err = lookupCryptoSpec(int(binary.BigEndian.Uint16(payload[2:]))); err != nil {
+			return nil, err
+		}
+		d.encPub = b[:d.crypto.pubLen]
+		if d.sig.pubLen > signingKeyField {
+			// the excess of the signing key lives in the certificate
+			excess := d.sig.pubLen - signingKeyField
+			if len(payload) < 4+excess {
+				return nil, errors.New("Key certificate too short")
+			}
+			d.sigPub = append(append([]byte{}, b[keysAndCertLen-signingKeyField:keysAndCertLen]...), payload[4:4+excess]...)
+		} else {
+			d.sigPub = b[keysAndCertLen-d.sig.pubLen : keysAndCertLen]
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("Unsupported certificate type %d", certType)
+}
+
+// the parsed layout of a private key blob, as returned by DEST GENERATE and
+// accepted by SESSION CREATE
+type privateKeys struct {
+	dest    *destination
+	encPriv []byte
+	sigPriv []byte
+	// the offline signature section, nil unless the keys are offline-signed
+	offline *offlineSection
+}
+
+func parsePrivateKeys(keys i2pkeys.I2PKeys) (*privateKeys, error) {
+	b, err := i2pB64.DecodeString(keys.String())
+	if err != nil {
+		return nil, errors.New("Private keys are not base64-encoded")
+	}
+	d, err := parseDestination(b)
+	if err != nil {
+		return nil, err
+	}
+	p := &privateKeys{dest: d}
+	rest := b[len(d.raw):]
+	if len(rest) < d.crypto.privLen+d.sig.privLen {
+		return nil, errors.New("Private keys truncated")
+	}
+	p.encPriv = rest[:d.crypto.privLen]
+	p.sigPriv = rest[d.crypto.privLen : d.crypto.privLen+d.sig.privLen]
+	rest = rest[d.crypto.privLen+d.sig.privLen:]
+	if isZero(p.sigPriv) {
+		// SAM 3.3: a zeroed signing key is followed by an offline signature
+		if p.offline, err = parseOfflineSection(rest, d.sig); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// the base64 private key blob for these keys
+func (p *privateKeys) String() string {
+	var b bytes.Buffer
+	b.Write(p.dest.raw)
+	b.Write(p.encPriv)
+	b.Write(p.sigPriv)
+	if p.offline != nil {
+		b.Write(p.offline.bytes())
+	}
+	return i2pB64.EncodeToString(b.Bytes())
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// newPrivateKeys generates fresh key pairs of the given types and lays them
+// out as a destination and private key blob, the way a router does for
+// DEST GENERATE.
+func newPrivateKeys(sig sigSpec, crypto cryptoSpec, r io.Reader) (*privateKeys, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	sigPub, sigPriv, err := generateSigningKey(sig, r)
+	if err != nil {
+		return nil, err
+	}
+	encPub, encPriv, err := generateEncryptionKey(crypto, r)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, keysAndCertLen, keysAndCertLen+7+len(sigPub))
+	copy(raw, encPub)
+	cert := []byte{certKey, 0, 4, byte(sig.code >> 8), byte(sig.code), byte(crypto.code >> 8), byte(crypto.code)}
+	if len(sigPub) > signingKeyField {
+		copy(raw[keysAndCertLen-signingKeyField:], sigPub[:signingKeyField])
+		cert = append(cert, sigPub[signingKeyField:]...)
+		binary.BigEndian.PutUint16(cert[1:], uint16(len(cert)-3))
+	} else {
+		copy(raw[keysAndCertLen-len(sigPub):], sigPub)
+	}
+	d, err := parseDestination(append(raw, cert...))
+	if err != nil {
+		return nil, err
+	}
+	// repeated random bytes keep the destination compressible, routers do
+	// the same since I2P 0.9.57
+	var seed [32]byte
+	if _, err := io.ReadFull(r, seed[:]); err != nil {
+		return nil, err
+	}
+	pad := d.padding()
+	for i := range pad {
+		pad[i] = seed[i%len(seed)]
+	}
+	return &privateKeys{dest: d, encPriv: encPriv, sigPriv: sigPriv}, nil
+}
+
+// the padding between the encryption and the signing key of the destination,
+// it can hold anything
+func (d *destination) padding() []byte {
+	end := keysAndCertLen - d.sig.pubLen
+	if d.sig.pubLen > signingKeyField {
+		end = keysAndCertLen - signingKeyField
+	}
+	return d.raw[d.crypto.pubLen:end]
+}
+
+// the keys in the form the rest of the library uses
+func (p *privateKeys) Keys() i2pkeys.I2PKeys {
+	return i2pkeys.NewKeys(i2pkeys.I2PAddr(i2pB64.EncodeToString(p.dest.raw)), p.String())
+}
+
+// The 2048 bit MODP group of RFC 3526, which I2P uses for ElGamal.
+var elgamalP, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+	"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+	"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+	"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D"+
+	"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F"+
+	"83655D23DCA3AD961C62F356208552BB9ED529077096966D"+
+	"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+	"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9"+
+	"DE2BCBF6955817183995497CEA956AE515D2261898FA0510"+
+	"15728E5A8AACAA68FFFFFFFFFFFFFFFF", 16)
+
+// generates an encryption key pair in I2Ps wire format
+func generateEncryptionKey(crypto cryptoSpec, r io.Reader) (pub, priv []byte, err error) {
+	switch crypto.code {
+	case 0:
+		// short exponents, like the Java router
+		x, err := rand.Int(r, new(big.Int).Lsh(big.NewInt(1), 226))
+		if err != nil {
+			return nil, nil, err
+		}
+		x.Add(x, big.NewInt(2))
+		y := new(big.Int).Exp(big.NewInt(2), x, elgamalP)
+		pub = make([]byte, crypto.pubLen)
+		priv = make([]byte, crypto.privLen)
+		y.FillBytes(pub)
+		x.FillBytes(priv)
+		return pub, priv, nil
+	case 4:
+		k, err := ecdh.X25519().GenerateKey(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return k.PublicKey().Bytes(), k.Bytes(), nil
+	}
+	return nil, nil, errors.New("Can not generate " + crypto.name + " keys locally")
+}
+
+// generates a signing key pair in I2Ps wire format
+func generateSigningKey(spec sigSpec, r io.Reader) (pub, priv []byte, err error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	switch spec.code {
+	case 7:
+		pk, sk, err := ed25519.GenerateKey(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []byte(pk), sk.Seed(), nil
+	case 1, 2, 3:
+		sk, err := ecdsa.GenerateKey(sigCurve(spec), r)
+		if err != nil {
+			return nil, nil, err
+		}
+		size := spec.privLen
+		pub = make([]byte, 2*size)
+		sk.X.FillBytes(pub[:size])
+		sk.Y.FillBytes(pub[size:])
+		priv = make([]byte, size)
+		sk.D.FillBytes(priv)
+		return pub, priv, nil
+	}
+	return nil, nil, errors.New("Can not generate " + spec.name + " keys locally")
+}
+
+func sigCurve(spec sigSpec) elliptic.Curve {
+	switch spec.code {
+	case 1:
+		return elliptic.P256()
+	case 2:
+		return elliptic.P384()
+	case 3:
+		return elliptic.P521()
+	}
+	return nil
+}
+
+// signs data with a signing private key in I2Ps wire format
+func sign(spec sigSpec, priv, data []byte) ([]byte, error) {
+	switch spec.code {
+	case 7:
+		if len(priv) != ed25519.SeedSize {
+			return nil, errors.New("Invalid EdDSA private key")
+		}
+		return ed25519.Sign(ed25519.NewKeyFromSeed(priv), data), nil
+	case 1, 2, 3:
+		sk, err := ecdsaKey(spec, priv)
+		if err != nil {
+			return nil, err
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, sk, sigDigest(spec, data))
+		if err != nil {
+			return nil, err
+		}
+		size := spec.sigLen / 2
+		sig := make([]byte, spec.sigLen)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	}
+	return nil, errors.New("Can not sign with " + spec.name + " keys")
+}
+
+// verifies a signature in I2Ps wire format
+func verify(spec sigSpec, pub, data, sig []byte) bool {
+	if len(pub) != spec.pubLen || len(sig) != spec.sigLen {
+		return false
+	}
+	switch spec.code {
+	case 7:
+		return ed25519.Verify(ed25519.PublicKey(pub), data, sig)
+	case 1, 2, 3:
+		size := spec.pubLen / 2
+		pk := &ecdsa.PublicKey{
+			Curve: sigCurve(spec),
+			X:     new(big.Int).SetBytes(pub[:size]),
+			Y:     new(big.Int).SetBytes(pub[size:]),
+		}
+		half := spec.sigLen / 2
+		r := new(big.Int).SetBytes(sig[:half])
+		s := new(big.Int).SetBytes(sig[half:])
+		return ecdsa.Verify(pk, sigDigest(spec, data), r, s)
+	}
+	return false
+}
+
+func sigDigest(spec sigSpec, data []byte) []byte {
+	switch spec.code {
+	case 2:
+		h := sha512.Sum384(data)
+		return h[:]
+	case 3:
+		h := sha512.Sum512(data)
+		return h[:]
+	}
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+func ecdsaKey(spec sigSpec, priv []byte) (*ecdsa.PrivateKey, error) {
+	var curve ecdh.Curve
+	switch spec.code {
+	case 1:
+		curve = ecdh.P256()
+	case 2:
+		curve = ecdh.P384()
+	case 3:
+		curve = ecdh.P521()
+	}
+	// let crypto/ecdh validate the scalar and derive the public point
+	k, err := curve.NewPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	point := k.PublicKey().Bytes()[1:]
+	size := len(point) / 2
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: sigCurve(spec),
+			X:     new(big.Int).SetBytes(point[:size]),
+			Y:     new(big.Int).SetBytes(point[size:]),
+		},
+		D: new(big.Int).SetBytes(priv),
+	}, nil
+}
@@ -0,0 +1,89 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// DefaultFallbackDelay is how long DialAny waits for a destination before it
+// also tries the next one, if the session's FallbackDelay is zero.
+const DefaultFallbackDelay = 2 * time.Second
+
+// DialAny connects to whichever of addrs answers first, like happy eyeballs
+// does for IPv6 and IPv4. The destinations are tried in order, each one
+// FallbackDelay after the one before, or right away when the one before
+// failed. The first connection that succeeds is returned with the index of
+// its destination in addrs, the other attempts are canceled and their
+// connections closed. If all of them fail the error of the first one is
+// returned.
+func (s *StreamSession) DialAny(ctx context.Context, addrs []i2pkeys.I2PAddr) (*SAMConn, int, error) {
+	if len(addrs) == 0 {
+		return nil, -1, errors.New("No destinations to dial")
+	}
+	ctx, stop := s.dialContext(ctx)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	delay := s.FallbackDelay
+	if delay <= 0 {
+		delay = DefaultFallbackDelay
+	}
+	type result struct {
+		conn *SAMConn
+		i    int
+		err  error
+	}
+	results := make(chan result)
+	dial := func(i int) {
+		conn, err := s.dialI2P(ctx, addrs[i])
+		select {
+		case results <- result{conn, i, err}:
+		case <-ctx.Done():
+			// lost, nobody is waiting for it anymore
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+
+	errs := make([]error, len(addrs))
+	next, running := 0, 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, -1, ctx.Err()
+		case <-timer.C:
+			if next < len(addrs) {
+				go dial(next)
+				next++
+				running++
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			running--
+			if r.err == nil {
+				return r.conn, r.i, nil
+			}
+			errs[r.i] = r.err
+			if next < len(addrs) {
+				// no need to wait, start the next one now
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(0)
+			} else if running == 0 {
+				for _, err := range errs {
+					if err != nil {
+						return nil, -1, err
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+func Test_DialAny(t *testing.T) {
+	fb, ss := newLifecycleSession(t)
+	defer ss.Close()
+	a, b, c := newTestAddr(t), newTestAddr(t), newTestAddr(t)
+	ctx := context.Background()
+
+	if _, _, err := ss.DialAny(ctx, nil); err == nil {
+		t.Error("no error dialing no destinations")
+	}
+
+	// a failed destination does not wait for the fallback delay
+	ss.FallbackDelay = time.Minute
+	fb.mu.Lock()
+	fb.dests = map[string]string{a.Base64(): "CANT_REACH_PEER"}
+	fb.mu.Unlock()
+	conn, i, err := ss.DialAny(ctx, []i2pkeys.I2PAddr{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if i != 1 {
+		t.Errorf("connected to destination %d, want 1", i)
+	}
+
+	// a slow destination is raced by the next one
+	ss.FallbackDelay = 50 * time.Millisecond
+	fb.mu.Lock()
+	fb.dests = map[string]string{a.Base64(): "wait"}
+	fb.mu.Unlock()
+	start := time.Now()
+	conn, i, err = ss.DialAny(ctx, []i2pkeys.I2PAddr{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if i != 1 {
+		t.Errorf("connected to destination %d, want 1", i)
+	}
+	if d := time.Since(start); d < ss.FallbackDelay {
+		t.Errorf("second destination dialed after %v, before the fallback delay", d)
+	}
+
+	// all of them fail, the first error is returned
+	fb.mu.Lock()
+	fb.dests = map[string]string{a.Base64(): "CANT_REACH_PEER", b.Base64(): "INVALID_KEY", c.Base64(): "TIMEOUT"}
+	fb.mu.Unlock()
+	_, i, err = ss.DialAny(ctx, []i2pkeys.I2PAddr{a, b, c})
+	var serr *SAMError
+	if !errors.As(err, &serr) || serr.Result != "CANT_REACH_PEER" || i != -1 {
+		t.Errorf("all destinations failed: %d %v, want the first error", i, err)
+	}
+	if n := fb.count("STREAM CONNECT"); n != 7 {
+		t.Errorf("bridge got %d STREAM CONNECTs, want 7", n)
+	}
+
+	// a context that ends first
+	fb.mu.Lock()
+	fb.dests = map[string]string{a.Base64(): "wait", b.Base64(): "wait"}
+	fb.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, _, err := ss.DialAny(ctx, []i2pkeys.I2PAddr{a, b}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("dial past the deadline: %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -26,17 +26,15 @@ func SetType(s string) func(*SAMEmit) error {
 	}
 }
 
-// SetSAMAddress sets the SAM address all-at-once
+// SetSAMAddress sets the SAM address all-at-once, host:port, [ipv6]:port or
+// just a host, with the default port
 func SetSAMAddress(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
-		sp := strings.Split(s, ":")
-		if len(sp) > 2 {
-			return fmt.Errorf("Invalid address string: %s", sp)
-		}
-		if len(sp) == 2 {
-			c.I2PConfig.SamPort = sp[1]
+		host, port, err := splitSAMAddress(s)
+		if err != nil {
+			return fmt.Errorf("Invalid address string: %s", s)
 		}
-		c.I2PConfig.SamHost = sp[0]
+		c.I2PConfig.SamHost, c.I2PConfig.SamPort = host, port
 		return nil
 	}
 }
@@ -72,6 +70,17 @@ func SetName(s string) func(*SAMEmit) error {
 	}
 }
 
+// SetIDPrefix sets what generated tunnel names start with
+func SetIDPrefix(s string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if strings.ContainsAny(s, " \t\n=") {
+			return fmt.Errorf("Invalid tunnel name prefix %q", s)
+		}
+		c.I2PConfig.IDPrefix = s
+		return nil
+	}
+}
+
 // SetInLength sets the number of hops inbound
 func SetInLength(u int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
@@ -367,3 +376,51 @@ func SetAccessList(s []string) func(*SAMEmit) error {
 		return nil
 	}
 }
+
+// SetBandwidthIn sets how many bytes per second a session may read over all
+// its connections, 0 for no limit
+func SetBandwidthIn(u int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if u >= 0 {
+			c.I2PConfig.BandwidthIn = strconv.Itoa(u)
+			return nil
+		}
+		return fmt.Errorf("Invalid inbound bandwidth %v", u)
+	}
+}
+
+// SetBandwidthOut sets how many bytes per second a session may write over all
+// its connections, 0 for no limit
+func SetBandwidthOut(u int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if u >= 0 {
+			c.I2PConfig.BandwidthOut = strconv.Itoa(u)
+			return nil
+		}
+		return fmt.Errorf("Invalid outbound bandwidth %v", u)
+	}
+}
+
+// SetBandwidthInConn sets how many bytes per second each connection may read,
+// 0 for no limit
+func SetBandwidthInConn(u int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if u >= 0 {
+			c.I2PConfig.BandwidthInConn = strconv.Itoa(u)
+			return nil
+		}
+		return fmt.Errorf("Invalid inbound connection bandwidth %v", u)
+	}
+}
+
+// SetBandwidthOutConn sets how many bytes per second each connection may
+// write, 0 for no limit
+func SetBandwidthOutConn(u int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if u >= 0 {
+			c.I2PConfig.BandwidthOutConn = strconv.Itoa(u)
+			return nil
+		}
+		return fmt.Errorf("Invalid outbound connection bandwidth %v", u)
+	}
+}
@@ -0,0 +1,31 @@
+package sam3
+
+// SAMError is a RESULT other than OK from the SAM bridge. Errors with the same
+// Result match with errors.Is, so the Err* values below can be used to tell
+// failures apart.
+type SAMError struct {
+	// the RESULT value, like CANT_REACH_PEER
+	Result  string
+	Message string
+}
+
+func (e *SAMError) Error() string {
+	return e.Message
+}
+
+func (e *SAMError) Is(target error) bool {
+	t, ok := target.(*SAMError)
+	return ok && t.Result == e.Result
+}
+
+var (
+	ErrCantReachPeer = &SAMError{"CANT_REACH_PEER", "Can not reach peer"}
+	ErrI2PError      = &SAMError{"I2P_ERROR", "I2P internal error"}
+	ErrInvalidKey    = &SAMError{"INVALID_KEY", "Invalid key"}
+	ErrInvalidID     = &SAMError{"INVALID_ID", "Invalid tunnel ID"}
+	ErrTimeout       = &SAMError{"TIMEOUT", "Timeout"}
+	ErrKeyNotFound   = &SAMError{"KEY_NOT_FOUND", "Key not found"}
+
+	ErrDuplicatedID   = &SAMError{"DUPLICATED_ID", "Duplicate tunnel name"}
+	ErrDuplicatedDest = &SAMError{"DUPLICATED_DEST", "Duplicate destination"}
+)
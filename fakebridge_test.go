@@ -0,0 +1,302 @@
+package sam3
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// fakeBridge answers just enough of SAM for a stream session, and records the
+// commands it gets.
+type fakeBridge struct {
+	l    net.Listener
+	mu   sync.Mutex
+	cmds []string
+	// the keys of the last TRANSIENT destination
+	transient i2pkeys.I2PKeys
+	// how many more SESSION CREATEs are refused with DUPLICATED_ID
+	dupIDs int
+	// SESSION CREATEs are not answered, like a router that cannot build
+	// tunnels, and dropped counts the clients that gave up on them
+	stall   bool
+	dropped int
+	// how many more STREAM CONNECTs fail with CANT_REACH_PEER
+	unreachable int
+	// how many more STREAM CONNECTs are not answered at all
+	hangups int
+	// after a STREAM CONNECT succeeds, its connection is handed to peer,
+	// like to the destination dialed, if set
+	peer func(net.Conn)
+	// the RESULT STREAM CONNECTs to some base64 destinations get, or "wait"
+	// to not answer until the client hangs up
+	dests map[string]string
+	// STREAM ACCEPTs wait for a peer that never comes
+	idle bool
+	// HELLOs are not answered, like a bridge that is overloaded
+	mute bool
+	// what the peer of STREAM ACCEPTs sends right after its destination
+	early string
+	// after a STREAM ACCEPT succeeds, its connection is handed to client,
+	// which plays the remote side
+	client func(net.Conn)
+	// who the next STREAM ACCEPTs come from, a new destination once it is
+	// empty
+	peers []i2pkeys.I2PAddr
+	// what NAMING LOOKUP finds
+	names map[string]i2pkeys.I2PAddr
+	// the open connections, closed by shutdown
+	conns map[net.Conn]bool
+}
+
+func newFakeBridge(t *testing.T, network, addr string) *fakeBridge {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	return serveFakeBridge(t, l)
+}
+
+// serveFakeBridge answers the connections of l, which may be a TLS listener
+func serveFakeBridge(t *testing.T, l net.Listener) *fakeBridge {
+	b := &fakeBridge{l: l}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(c)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return b
+}
+
+func (b *fakeBridge) serve(c net.Conn) {
+	b.mu.Lock()
+	if b.conns == nil {
+		b.conns = make(map[net.Conn]bool)
+	}
+	b.conns[c] = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+		c.Close()
+	}()
+	rd := bufio.NewReader(c)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		b.mu.Lock()
+		b.cmds = append(b.cmds, f[0]+" "+f[1])
+		b.mu.Unlock()
+		if f[0] == "PING" {
+			c.Write([]byte("PONG" + strings.TrimPrefix(line, "PING")))
+			continue
+		}
+		switch f[0] + " " + f[1] {
+		case "HELLO VERSION":
+			b.mu.Lock()
+			mute := b.mute
+			b.mu.Unlock()
+			if mute {
+				rd.ReadString('\n')
+				return
+			}
+			c.Write([]byte("HELLO REPLY RESULT=OK VERSION=3.3\n"))
+		case "SESSION CREATE":
+			b.mu.Lock()
+			dup, stall := b.dupIDs > 0, b.stall
+			if dup {
+				b.dupIDs--
+			}
+			b.mu.Unlock()
+			if stall {
+				rd.ReadString('\n')
+				b.mu.Lock()
+				b.dropped++
+				b.mu.Unlock()
+				return
+			}
+			if dup {
+				c.Write([]byte("SESSION STATUS RESULT=DUPLICATED_ID\n"))
+				return
+			}
+			dest, sig := "", ""
+			for _, kv := range f {
+				if strings.HasPrefix(kv, "DESTINATION=") {
+					dest = kv[len("DESTINATION="):]
+				} else if strings.HasPrefix(kv, "SIGNATURE_TYPE=") {
+					sig = kv
+				}
+			}
+			if dest == "TRANSIENT" {
+				keys, err := NewLocalKeys(sig)
+				if err != nil {
+					c.Write([]byte("SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"" + err.Error() + "\"\n"))
+					continue
+				}
+				b.mu.Lock()
+				b.transient = keys
+				b.mu.Unlock()
+				dest = keys.String()
+			}
+			c.Write([]byte("SESSION STATUS RESULT=OK DESTINATION=" + dest + "\n"))
+		case "NAMING LOOKUP":
+			name := ""
+			for _, kv := range f {
+				if strings.HasPrefix(kv, "NAME=") {
+					name = kv[len("NAME="):]
+				}
+			}
+			b.mu.Lock()
+			addr, ok := b.names[name]
+			b.mu.Unlock()
+			if !ok {
+				c.Write([]byte("NAMING REPLY RESULT=KEY_NOT_FOUND NAME=" + name + "\n"))
+				continue
+			}
+			c.Write([]byte("NAMING REPLY RESULT=OK NAME=" + name + " VALUE=" + addr.Base64() + "\n"))
+		case "STREAM CONNECT":
+			dest := ""
+			for _, kv := range f {
+				if strings.HasPrefix(kv, "DESTINATION=") {
+					dest = kv[len("DESTINATION="):]
+				}
+			}
+			b.mu.Lock()
+			result := b.dests[dest]
+			b.mu.Unlock()
+			if result == "wait" {
+				rd.ReadString('\n')
+				return
+			} else if result != "" {
+				c.Write([]byte("STREAM STATUS RESULT=" + result + "\n"))
+				return
+			}
+			b.mu.Lock()
+			unreachable, hangup := b.unreachable > 0, b.hangups > 0
+			if unreachable {
+				b.unreachable--
+			} else if hangup {
+				b.hangups--
+			}
+			b.mu.Unlock()
+			if hangup && !unreachable {
+				return
+			}
+			if unreachable {
+				c.Write([]byte("STREAM STATUS RESULT=CANT_REACH_PEER\n"))
+				return
+			}
+			c.Write([]byte("STREAM STATUS RESULT=OK\n"))
+			b.mu.Lock()
+			peer := b.peer
+			b.mu.Unlock()
+			if peer != nil {
+				peer(&readerConn{c, rd})
+				return
+			}
+		case "STREAM ACCEPT":
+			b.mu.Lock()
+			idle := b.idle
+			b.mu.Unlock()
+			if idle {
+				c.Write([]byte("STREAM STATUS RESULT=OK\n"))
+				rd.ReadString('\n')
+				return
+			}
+			var peer i2pkeys.I2PAddr
+			b.mu.Lock()
+			if len(b.peers) > 0 {
+				peer, b.peers = b.peers[0], b.peers[1:]
+			}
+			b.mu.Unlock()
+			if peer == "" {
+				keys, _ := NewLocalKeys()
+				peer = keys.Addr()
+			}
+			b.mu.Lock()
+			early, client := b.early, b.client
+			b.mu.Unlock()
+			c.Write([]byte("STREAM STATUS RESULT=OK\n" + peer.Base64() + " FROM_PORT=0 TO_PORT=0\n" + early))
+			if client != nil {
+				client(&readerConn{c, rd})
+				return
+			}
+			if early != "" {
+				// the rest is the peer's, which echoes
+				io.Copy(c, rd)
+				return
+			}
+		}
+	}
+}
+
+func (b *fakeBridge) count(cmd string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, c := range b.cmds {
+		if c == cmd {
+			n++
+		}
+	}
+	return n
+}
+
+// shutdown goes away like a router that stopped, closing the listener and
+// all connections
+func (b *fakeBridge) shutdown() {
+	b.l.Close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.conns {
+		c.Close()
+	}
+}
+
+// a connection whose first bytes were read into r already
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// httpPeer answers the HTTP requests on a connection with h
+func httpPeer(h http.HandlerFunc) func(net.Conn) {
+	return func(c net.Conn) {
+		rd := bufio.NewReader(c)
+		for {
+			req, err := http.ReadRequest(rd)
+			if err != nil {
+				return
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			res := rec.Result()
+			res.ContentLength = int64(rec.Body.Len())
+			if err := res.Write(c); err != nil {
+				return
+			}
+		}
+	}
+}
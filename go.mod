@@ -1,9 +1,8 @@
 module github.com/ivobilic/waSAM
 
-go 1.12
+go 1.21
 
 require (
 	github.com/eyedeekay/i2pkeys v0.33.7
-	github.com/eyedeekay/sam3 v0.33.7
 	github.com/stealthrocket/net v0.2.1
 )
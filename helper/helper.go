@@ -1,39 +1,65 @@
 package sam
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
-	"os"
 
 	"github.com/eyedeekay/i2pkeys"
-	"github.com/eyedeekay/sam3"
+	sam3 "github.com/ivobilic/waSAM"
 )
 
 // HEY! If you're looking at this, there's a good chance that `github.com/eyedeekay/onramp`
 // is a better fit! Check it out.
 
 func NetListener(name, samaddr, keyspath string) (net.Listener, error) {
-	return I2PListener(name, sam3.SAMDefaultAddr(samaddr), keyspath)
+	l, err := I2PListener(name, sam3.SAMDefaultAddr(samaddr), keyspath)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Listener is a sam3.StreamListener on a session of its own, which Close
+// closes as well.
+type Listener struct {
+	*sam3.StreamListener
+}
+
+// Close closes the listener and takes the service down with its session.
+func (l *Listener) Close() error {
+	err := l.StreamListener.Close()
+	if cerr := l.Session().Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 // I2PListener is a convenience function which takes a SAM tunnel name, a SAM address and a filename.
 // If the file contains I2P keys, it will create a service using that address. If the file does not
-// exist, keys will be generated and stored in that file.
-func I2PListener(name, samaddr, keyspath string) (*sam3.StreamListener, error) {
+// exist, keys will be generated and stored in that file. Closing the listener
+// closes the session too.
+func I2PListener(name, samaddr, keyspath string) (*Listener, error) {
 	log.Printf("Starting and registering I2P service, please wait a couple of minutes...")
-	listener, err := I2PStreamSession(name, sam3.SAMDefaultAddr(samaddr), keyspath)
+	session, err := I2PStreamSession(name, sam3.SAMDefaultAddr(samaddr), keyspath)
 	if err != nil {
 		return nil, err
 	}
 	if keyspath != "" {
-		err = ioutil.WriteFile(keyspath+".i2p.public.txt", []byte(listener.Keys().Addr().Base32()), 0644)
+		err = ioutil.WriteFile(keyspath+".i2p.public.txt", []byte(session.Keys().Addr().Base32()), 0644)
 		if err != nil {
-			log.Fatalf("error storing I2P base32 address in adjacent text file, %s", err)
+			session.Close()
+			return nil, fmt.Errorf("error storing I2P base32 address in adjacent text file: %w", err)
 		}
 	}
-	log.Printf("Listening on: %s", listener.Addr().Base32())
-	return listener.Listen()
+	log.Printf("Listening on: %s", session.Addr().Base32())
+	l, err := session.Listen()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &Listener{l}, nil
 }
 
 // I2PStreamSession is a convenience function which returns a sam3.StreamSession instead
@@ -43,88 +69,55 @@ func I2PStreamSession(name, samaddr, keyspath string) (*sam3.StreamSession, erro
 	log.Printf("Starting and registering I2P session...")
 	sam, err := sam3.NewSAM(sam3.SAMDefaultAddr(samaddr))
 	if err != nil {
-		log.Fatalf("error connecting to SAM to %s: %s", sam3.SAMDefaultAddr(samaddr), err)
+		return nil, fmt.Errorf("error connecting to SAM at %s: %w", sam3.SAMDefaultAddr(samaddr), err)
 	}
 	keys, err := GenerateOrLoadKeys(keyspath, sam)
 	if err != nil {
+		sam.Close()
 		return nil, err
 	}
 	stream, err := sam.NewStreamSession(name, *keys, sam3.Options_Medium)
-	return stream, err
-}
-
-// I2PDataGramsession is a convenience function which returns a sam3.DatagramSession.
-// It also takes care of setting a persisitent key on behalf of the user.
-func I2PDatagramSession(name, samaddr, keyspath string) (*sam3.DatagramSession, error) {
-	log.Printf("Starting and registering I2P session...")
-	sam, err := sam3.NewSAM(sam3.SAMDefaultAddr(samaddr))
-	if err != nil {
-		log.Fatalf("error connecting to SAM to %s: %s", sam3.SAMDefaultAddr(samaddr), err)
-	}
-	keys, err := GenerateOrLoadKeys(keyspath, sam)
 	if err != nil {
-		return nil, err
+		sam.Close()
+		return nil, fmt.Errorf("error creating I2P session %s: %w", name, err)
 	}
-	gram, err := sam.NewDatagramSession(name, *keys, sam3.Options_Medium, 0)
-	return gram, err
+	return stream, nil
 }
 
-// I2PPrimarySession is a convenience function which returns a sam3.PrimarySession.
-// It also takes care of setting a persisitent key on behalf of the user.
-func I2PPrimarySession(name, samaddr, keyspath string) (*sam3.PrimarySession, error) {
-	log.Printf("Starting and registering I2P session...")
-	sam, err := sam3.NewSAM(sam3.SAMDefaultAddr(samaddr))
-	if err != nil {
-		log.Fatalf("error connecting to SAM to %s: %s", sam3.SAMDefaultAddr(samaddr), err)
-	}
-	keys, err := GenerateOrLoadKeys(keyspath, sam)
-	if err != nil {
-		return nil, err
-	}
-	gram, err := sam.NewPrimarySession(name, *keys, sam3.Options_Medium)
-	return gram, err
+// Dialer is a convenience function for clients. The returned sam3.StreamSession
+// dials I2P destinations and names with Dial and DialContext, for example from an
+// http.Transport. With an empty keyspath the session uses throwaway keys.
+func Dialer(name, samaddr, keyspath string) (*sam3.StreamSession, error) {
+	return I2PStreamSession(name, samaddr, keyspath)
 }
 
 // GenerateOrLoadKeys is a convenience function which takes a filename and a SAM session.
-// if the SAM session is nil, a new one will be created with the defaults.
+// if the SAM session is nil, the keys are generated locally, without a router.
 // The keyspath must be the path to a place to store I2P keys. The keyspath will be suffixed with
 // .i2p.private for the private keys, and public.txt for the b32 addresses.
 // If the keyspath.i2p.private file does not exist, keys will be generated and stored in that file.
-// if the keyspath.i2p.private does exist, keys will be loaded from that location and returned
-func GenerateOrLoadKeys(keyspath string, sam *sam3.SAM) (keys *i2pkeys.I2PKeys, err error) {
-	if sam == nil {
-		sam, err = sam3.NewSAM(sam3.SAMDefaultAddr("127.0.0.1:7656"))
-		if err != nil {
-			return nil, err
-		}
+// if the keyspath.i2p.private does exist, keys will be loaded from that location and returned.
+// With an empty keyspath, new keys are generated and not stored.
+func GenerateOrLoadKeys(keyspath string, sam *sam3.SAM) (*i2pkeys.I2PKeys, error) {
+	fname := ""
+	if keyspath != "" {
+		fname = keyspath + ".i2p.private"
 	}
-	if _, err := os.Stat(keyspath + ".i2p.private"); os.IsNotExist(err) {
-		f, err := os.Create(keyspath + ".i2p.private")
-		if err != nil {
-			log.Fatalf("unable to open I2P keyfile for writing: %s", err)
-		}
-		defer f.Close()
-		tkeys, err := sam.NewKeys()
-		if err != nil {
-			log.Fatalf("unable to generate I2P Keys, %s", err)
-		}
-		keys = &tkeys
-		err = i2pkeys.StoreKeysIncompat(*keys, f)
-		if err != nil {
-			log.Fatalf("unable to save newly generated I2P Keys, %s", err)
-		}
+	var keys i2pkeys.I2PKeys
+	var err error
+	if sam == nil {
+		keys, err = sam3.EnsureKeyfile(fname)
 	} else {
-		tkeys, err := i2pkeys.LoadKeys(keyspath + ".i2p.private")
-		if err != nil {
-			log.Fatalf("unable to load I2P Keys: %e", err)
-		}
-		keys = &tkeys
+		keys, err = sam.EnsureKeyfile(fname)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate or load I2P keys: %w", err)
 	}
-	return keys, nil
+	return &keys, nil
 }
 
 // GenerateKeys is a shorter version of GenerateOrLoadKeys which generates keys and stores them in a file.
-// it always uses a new default SAM session.
+// it generates the keys locally and works without a router.
 func GenerateKeys(keyspath string) (keys *i2pkeys.I2PKeys, err error) {
 	return GenerateOrLoadKeys(keyspath, nil)
 }
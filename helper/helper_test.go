@@ -0,0 +1,140 @@
+package sam
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBridge answers HELLO and SESSION CREATE, and counts the connections
+// that are still open
+func fakeBridge(t *testing.T) (addr string, open func() int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	conns := make(chan int, 100)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- 1
+			go func() {
+				defer func() { conns <- -1 }()
+				defer c.Close()
+				rd := bufio.NewReader(c)
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch {
+					case strings.HasPrefix(line, "HELLO"):
+						c.Write([]byte("HELLO REPLY RESULT=OK VERSION=3.3\n"))
+					case strings.HasPrefix(line, "SESSION CREATE"):
+						dest := ""
+						for _, kv := range strings.Fields(line) {
+							if strings.HasPrefix(kv, "DESTINATION=") {
+								dest = kv[len("DESTINATION="):]
+							}
+						}
+						c.Write([]byte("SESSION STATUS RESULT=OK DESTINATION=" + dest + "\n"))
+					}
+				}
+			}()
+		}
+	}()
+	n := 0
+	return l.Addr().String(), func() int {
+		for {
+			select {
+			case d := <-conns:
+				n += d
+			default:
+				return n
+			}
+		}
+	}
+}
+
+func Test_I2PListener(t *testing.T) {
+	addr, open := fakeBridge(t)
+	keyspath := filepath.Join(t.TempDir(), "service")
+	keys, err := GenerateKeys(keyspath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := I2PListener("service", addr, keyspath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Addr().String() != keys.Addr().Base32() {
+		t.Errorf("listening on %s, want the stored keys' %s", l.Addr(), keys.Addr().Base32())
+	}
+	b32, err := os.ReadFile(keyspath + ".i2p.public.txt")
+	if err != nil || string(b32) != keys.Addr().Base32() {
+		t.Errorf("public address file has %q, %v", b32, err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-l.Session().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Close left the session open")
+	}
+	for deadline := time.Now().Add(time.Second); open() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections to the bridge left open", open())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_NetListenerError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	nl, err := NetListener("service", addr, "")
+	if err == nil {
+		t.Fatal("no error without a bridge")
+	}
+	if nl != nil {
+		t.Errorf("NetListener returned %#v with the error, want nil", nl)
+	}
+}
+
+func Test_GenerateOrLoadKeys(t *testing.T) {
+	keyspath := filepath.Join(t.TempDir(), "k")
+	keys, err := GenerateOrLoadKeys(keyspath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := GenerateOrLoadKeys(keyspath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != keys.String() {
+		t.Error("stored keys were not loaded again")
+	}
+	if _, err := os.Stat(keyspath + ".i2p.private"); err != nil {
+		t.Error(err)
+	}
+	throwaway, err := GenerateOrLoadKeys("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if throwaway.String() == keys.String() {
+		t.Error("throwaway keys are the stored ones")
+	}
+}
@@ -0,0 +1,83 @@
+package sam3
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Timeouts for HTTP over I2P. Building a path to a destination the router
+// has not talked to recently can take most of a minute, so they are far more
+// generous than what is usual on the clearnet.
+const (
+	HTTPResponseHeaderTimeout = 2 * time.Minute
+	HTTPTLSHandshakeTimeout   = time.Minute
+	HTTPIdleConnTimeout       = 5 * time.Minute
+	HTTPMaxIdleConnsPerHost   = 4
+)
+
+// IsI2PHost reports if host, with or without a port, is a .i2p or .b32.i2p name.
+func IsI2PHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	_, ok := i2pName(host)
+	return ok
+}
+
+// i2pName returns host lowercased and without a trailing dot, and whether it
+// is a .i2p or .b32.i2p name then.
+func i2pName(host string) (string, bool) {
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	return name, strings.HasSuffix(name, ".i2p")
+}
+
+// NewHTTPTransport returns an http.Transport which makes requests to eepsites
+// through the session. Hosts ending in .i2p are looked up and dialed with
+// DialContext, idle connections are kept per destination and reused.
+//
+// Requests to other hosts go through the HTTP outproxy at the URL outproxy,
+// for example "http://exit.stormycloud.i2p", which is reached over I2P too.
+// If outproxy is empty, requests to other hosts fail.
+func NewHTTPTransport(s *StreamSession, outproxy string) (*http.Transport, error) {
+	var proxyURL *url.URL
+	if outproxy != "" {
+		var err error
+		proxyURL, err = url.Parse(outproxy)
+		if err != nil {
+			return nil, err
+		}
+		if !IsI2PHost(proxyURL.Host) {
+			return nil, errors.New("Outproxy " + outproxy + " is not an I2P site")
+		}
+	}
+	return &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			if IsI2PHost(req.URL.Host) {
+				return nil, nil
+			}
+			if proxyURL == nil {
+				return nil, errors.New("No outproxy configured for " + req.URL.Host)
+			}
+			return proxyURL, nil
+		},
+		DialContext:           s.DialContext,
+		ResponseHeaderTimeout: HTTPResponseHeaderTimeout,
+		TLSHandshakeTimeout:   HTTPTLSHandshakeTimeout,
+		IdleConnTimeout:       HTTPIdleConnTimeout,
+		MaxIdleConnsPerHost:   HTTPMaxIdleConnsPerHost,
+		ExpectContinueTimeout: 5 * time.Second,
+	}, nil
+}
+
+// NewHTTPClient returns an http.Client using NewHTTPTransport.
+func NewHTTPClient(s *StreamSession, outproxy string) (*http.Client, error) {
+	tr, err := NewHTTPTransport(s, outproxy)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: tr}, nil
+}
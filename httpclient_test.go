@@ -0,0 +1,111 @@
+package sam3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+func Test_IsI2PHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"site.i2p":          true,
+		"Site.I2P":          true,
+		"site.i2p.":         true,
+		"site.i2p:8080":     true,
+		"abc.b32.i2p":       true,
+		"example.com":       false,
+		"i2p.example.com":   false,
+		"example.com:443":   false,
+		"127.0.0.1":         false,
+		"[::1]:80":          false,
+		"site.i2p.example.": false,
+	} {
+		if IsI2PHost(host) != want {
+			t.Errorf("IsI2PHost(%q) = %v, want %v", host, !want, want)
+		}
+	}
+}
+
+func Test_ResolveI2PName(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	site := newTestAddr(t)
+	b.mu.Lock()
+	b.names = map[string]i2pkeys.I2PAddr{"site.i2p": site}
+	b.mu.Unlock()
+	for _, host := range []string{"site.i2p", "Site.I2P:80", "site.i2p."} {
+		addr, err := ss.resolve(context.Background(), host)
+		if err != nil {
+			t.Errorf("resolve(%q): %v", host, err)
+		} else if addr.Base32() != site.Base32() {
+			t.Errorf("resolve(%q) = %s, want %s", host, addr.Base32(), site.Base32())
+		}
+	}
+}
+
+func Test_HTTPTransport(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	b.mu.Lock()
+	b.names = map[string]i2pkeys.I2PAddr{"site.i2p": newTestAddr(t), "exit.i2p": newTestAddr(t)}
+	b.peer = httpPeer(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+" "+r.RequestURI)
+	})
+	b.mu.Unlock()
+	get := func(c *http.Client, url string) (string, error) {
+		resp, err := c.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	c, err := NewHTTPClient(ss, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{"http://site.i2p/a", "http://site.i2p/b", "http://Site.I2P./c"} {
+		body, err := get(c, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(body, " /"+url[len(url)-1:]) {
+			t.Errorf("GET %s: site got %q", url, body)
+		}
+	}
+	if n := b.count("STREAM CONNECT"); n != 2 {
+		// Site.I2P. is another host to the transport
+		t.Errorf("bridge got %d STREAM CONNECTs, want one per host", n)
+	}
+
+	if _, err := get(c, "http://example.com/"); err == nil || !strings.Contains(err.Error(), "outproxy") {
+		t.Errorf("GET of a clearnet site without an outproxy: %v", err)
+	}
+	if n := b.count("STREAM CONNECT"); n != 2 {
+		t.Error("clearnet site was dialed without an outproxy")
+	}
+
+	if _, err := NewHTTPClient(ss, "http://exit.example.com"); err == nil {
+		t.Error("outproxy outside of I2P accepted")
+	}
+	c, err = NewHTTPClient(ss, "http://exit.i2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := get(c, "http://example.com/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "example.com http://example.com/x" {
+		t.Errorf("outproxy got %q, want a proxy request for http://example.com/x", body)
+	}
+	if n := b.count("NAMING LOOKUP"); n != 3 {
+		t.Errorf("bridge got %d lookups, want site.i2p twice and exit.i2p", n)
+	}
+}
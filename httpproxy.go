@@ -0,0 +1,205 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// DefaultStripHeaders are removed from requests by an HTTPProxy unless its
+// StripHeaders is set, they tell the site about the client or where it came
+// from.
+var DefaultStripHeaders = []string{
+	"Referer",
+	"From",
+	"Via",
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-IP",
+}
+
+// DefaultUserAgent replaces the User-Agent of requests through an HTTPProxy,
+// it is the one i2ptunnel sends.
+const DefaultUserAgent = "MYOB/6.66 (AN/ON)"
+
+// hop-by-hop headers, never forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HTTPProxy is an http.Handler for browsers and other HTTP clients using it as
+// their proxy. CONNECT requests are tunneled to I2P sites, requests with an
+// absolute URI are forwarded to them. Names are looked up through the
+// session, see StreamSession.Lookup.
+type HTTPProxy struct {
+	Session *StreamSession
+	// headers to remove from forwarded requests, DefaultStripHeaders if nil
+	StripHeaders []string
+	// the User-Agent sent instead of the client's, DefaultUserAgent if
+	// empty, or "-" to keep the client's
+	UserAgent string
+	// CONNECT tunnels are closed after being idle for this long, if not zero
+	IdleTimeout time.Duration
+
+	transport *http.Transport
+}
+
+// NewHTTPProxy returns an HTTPProxy which connects through session.
+func NewHTTPProxy(session *StreamSession) *HTTPProxy {
+	p := &HTTPProxy{Session: session}
+	p.transport, _ = NewHTTPTransport(session, "")
+	p.transport.DialContext = p.dial
+	return p
+}
+
+func (p *HTTPProxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	// the lookups of the session reconnect to the bridge when they have to
+	i2paddr, err := p.Session.resolve(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return p.Session.dialI2P(ctx, i2paddr)
+}
+
+func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.connect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		proxyError(w, http.StatusBadRequest, "Not a proxy request", "This is an HTTP proxy to I2P sites, configure it as the proxy of your browser.")
+		return
+	}
+	if !IsI2PHost(r.URL.Host) {
+		proxyError(w, http.StatusForbidden, "Not an I2P site", r.URL.Host+" is not an I2P site, and there is no outproxy.")
+		return
+	}
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	p.clean(out.Header)
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		dialError(w, r.URL.Hostname(), err)
+		return
+	}
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// tunnels a CONNECT request
+func (p *HTTPProxy) connect(w http.ResponseWriter, r *http.Request) {
+	if !IsI2PHost(r.Host) {
+		proxyError(w, http.StatusForbidden, "Not an I2P site", r.Host+" is not an I2P site, and there is no outproxy.")
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		proxyError(w, http.StatusInternalServerError, "Can not tunnel", "The server does not support CONNECT.")
+		return
+	}
+	remote, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		host, _, _ := net.SplitHostPort(r.Host)
+		dialError(w, host, err)
+		return
+	}
+	defer remote.Close()
+	c, buf, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	// the client may have sent more than the request already
+	if n := buf.Reader.Buffered(); n > 0 {
+		b, _ := buf.Reader.Peek(n)
+		if _, err := remote.Write(b); err != nil {
+			return
+		}
+	}
+	Pipe(c, remote, p.IdleTimeout)
+}
+
+// strips hop-by-hop and identifying headers
+func (p *HTTPProxy) clean(h http.Header) {
+	removeHopHeaders(h)
+	strip := p.StripHeaders
+	if strip == nil {
+		strip = DefaultStripHeaders
+	}
+	for _, k := range strip {
+		h.Del(k)
+	}
+	switch p.UserAgent {
+	case "":
+		h.Set("User-Agent", DefaultUserAgent)
+	case "-":
+	default:
+		h.Set("User-Agent", p.UserAgent)
+	}
+}
+
+// removes the hop-by-hop headers, the fixed ones and the ones the Connection
+// header names
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, k := range strings.Split(v, ",") {
+			if k = textproto.TrimString(k); k != "" {
+				h.Del(k)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+// explains why host could not be reached
+func dialError(w http.ResponseWriter, host string, err error) {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		proxyError(w, http.StatusNotFound, "Website unknown",
+			"The router does not know the I2P site "+host+". Check the spelling, add it to the address book with a jump service, or use its .b32.i2p address.")
+	case errors.Is(err, ErrCantReachPeer):
+		proxyError(w, http.StatusGatewayTimeout, "Website unreachable",
+			"The I2P site "+host+" could not be reached. It may be down, or it just started and is not published yet. Try again in a bit.")
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		proxyError(w, http.StatusGatewayTimeout, "Website timed out",
+			"The I2P site "+host+" took too long to answer. Try again in a bit.")
+	default:
+		proxyError(w, http.StatusBadGateway, "Proxy error",
+			"Connecting to the I2P site "+host+" failed: "+err.Error())
+	}
+}
+
+func proxyError(w http.ResponseWriter, code int, title, text string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>%[1]s</title></head><body><h1>%[1]s</h1><p>%[2]s</p></body></html>\n",
+		html.EscapeString(title), html.EscapeString(text))
+}
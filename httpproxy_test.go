@@ -0,0 +1,70 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+func Test_HTTPProxyDial(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	site, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	b.names = map[string]i2pkeys.I2PAddr{"site.i2p": site.Addr()}
+	b.mu.Unlock()
+	p := NewHTTPProxy(ss)
+	conn, err := p.dial(context.Background(), "tcp", "site.i2p:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().String() != site.Addr().Base32() {
+		t.Errorf("dialed %s, want %s", conn.RemoteAddr(), site.Addr().Base32())
+	}
+	conn.Close()
+
+	// the lookup connection breaks, as when the router restarts
+	ss.mu.Lock()
+	ss.resolver.conn.Close()
+	ss.mu.Unlock()
+	conn, err = p.dial(context.Background(), "tcp", "site.i2p:80")
+	if err != nil {
+		t.Fatalf("dial after the lookup connection broke: %v", err)
+	}
+	conn.Close()
+	if _, err := p.dial(context.Background(), "tcp", "unknown.i2p:80"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("dial to an unknown site: %v, want ErrKeyNotFound", err)
+	}
+	if n := b.count("HELLO VERSION"); n != 5 {
+		// the session, two lookup connections and two dials
+		t.Errorf("bridge got %d connections, want 5", n)
+	}
+}
+
+func Test_HTTPProxyStripsHeaders(t *testing.T) {
+	p := &HTTPProxy{}
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Session-Token")
+	h.Add("Connection", "X-Other")
+	h.Set("X-Session-Token", "secret")
+	h.Set("X-Other", "1")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Referer", "http://example.i2p/")
+	h.Set("User-Agent", "Browser/1.0")
+	h.Set("Accept", "text/html")
+	p.clean(h)
+	for _, k := range []string{"Connection", "X-Session-Token", "X-Other", "Keep-Alive", "Referer"} {
+		if h.Get(k) != "" {
+			t.Errorf("header %s was forwarded", k)
+		}
+	}
+	if h.Get("User-Agent") != DefaultUserAgent || h.Get("Accept") != "text/html" {
+		t.Errorf("headers after clean: %v", h)
+	}
+}
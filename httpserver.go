@@ -0,0 +1,103 @@
+package sam3
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// Headers carrying the destination of the client, as set by i2ptunnel HTTP
+// server tunnels.
+const (
+	HeaderDestHash = "X-I2P-DestHash"
+	HeaderDestB64  = "X-I2P-DestB64"
+	HeaderDestB32  = "X-I2P-DestB32"
+)
+
+type contextKey int
+
+const remoteAddrKey contextKey = 0
+
+// RemoteI2PAddr returns the destination of the client that sent a request to
+// an HTTPServer, from the request context.
+func RemoteI2PAddr(ctx context.Context) (i2pkeys.I2PAddr, bool) {
+	addr, ok := ctx.Value(remoteAddrKey).(i2pkeys.I2PAddr)
+	return addr, ok
+}
+
+// HTTPServer serves HTTP on a StreamListener. Handlers find the destination of
+// the client with RemoteI2PAddr, and optionally in headers.
+type HTTPServer struct {
+	*http.Server
+	// set the X-I2P-Dest* headers on requests, like i2ptunnel does. Headers
+	// of that name sent by the client are always removed.
+	AddHeaders bool
+	listener   *StreamListener
+}
+
+// NewHTTPServer returns an HTTPServer for handler on l.
+func NewHTTPServer(l *StreamListener, handler http.Handler) *HTTPServer {
+	s := &HTTPServer{listener: l}
+	s.Server = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.destHeaders(r)
+			handler.ServeHTTP(w, r)
+		}),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if sc, ok := c.(*SAMConn); ok {
+				return context.WithValue(ctx, remoteAddrKey, sc.remoteAddr())
+			}
+			return ctx
+		},
+	}
+	return s
+}
+
+func (s *HTTPServer) destHeaders(r *http.Request) {
+	for k := range r.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-i2p-dest") {
+			r.Header.Del(k)
+		}
+	}
+	if !s.AddHeaders {
+		return
+	}
+	addr, ok := RemoteI2PAddr(r.Context())
+	if !ok {
+		return
+	}
+	h := addr.DestHash()
+	r.Header.Set(HeaderDestHash, i2pB64.EncodeToString(h[:]))
+	r.Header.Set(HeaderDestB64, addr.Base64())
+	r.Header.Set(HeaderDestB32, addr.Base32())
+}
+
+// Serve accepts connections on the listener and serves them until the server
+// is shut down or closed, then it returns http.ErrServerClosed.
+func (s *HTTPServer) Serve() error {
+	// closing the listener only ends the accept, the session stays up for
+	// the requests still in flight
+	return s.Server.Serve(s.listener)
+}
+
+// Shutdown stops accepting, waits for active requests to finish or ctx to be
+// done, and then closes the SAM session.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	if cerr := s.listener.Session().Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close closes the server, its connections and the SAM session right away.
+func (s *HTTPServer) Close() error {
+	err := s.Server.Close()
+	if cerr := s.listener.Session().Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package sam3
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+func Test_HTTPServerShutdown(t *testing.T) {
+	for _, shutdown := range []bool{true, false} {
+		b, ss := newLifecycleSession(t)
+		b.mu.Lock()
+		b.idle = true
+		b.mu.Unlock()
+		l, err := ss.Listen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewHTTPServer(l, http.NotFoundHandler())
+		served := make(chan error, 1)
+		go func() { served <- s.Serve() }()
+		for b.count("STREAM ACCEPT") == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if shutdown {
+			err = s.Shutdown(ctx)
+		} else {
+			err = s.Close()
+		}
+		cancel()
+		if err != nil {
+			t.Errorf("shutdown %v: %v", shutdown, err)
+		}
+		select {
+		case err := <-served:
+			if !errors.Is(err, http.ErrServerClosed) {
+				t.Errorf("Serve returned %v, want http.ErrServerClosed", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Serve still accepting")
+		}
+		select {
+		case <-ss.Done():
+		default:
+			t.Error("session still open")
+		}
+	}
+}
+
+func Test_HTTPServerHeaders(t *testing.T) {
+	for _, add := range []bool{true, false} {
+		b, ss := newLifecycleSession(t)
+		from := newTestAddr(t)
+		responses := make(chan *http.Response, 1)
+		b.mu.Lock()
+		b.peers = []i2pkeys.I2PAddr{from}
+		b.client = func(c net.Conn) {
+			req, _ := http.NewRequest("GET", "http://site.i2p/", nil)
+			// a client must not be able to claim another destination
+			req.Header.Set(HeaderDestB32, "forged.b32.i2p")
+			req.Header.Set("X-I2P-DestSomething", "forged")
+			req.Write(c)
+			resp, err := http.ReadResponse(bufio.NewReader(c), req)
+			if err != nil {
+				t.Error(err)
+				resp = nil
+			}
+			responses <- resp
+		}
+		b.mu.Unlock()
+		l, err := ss.Listen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests := make(chan *http.Request, 1)
+		s := NewHTTPServer(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- r
+		}))
+		s.AddHeaders = add
+		go s.Serve()
+
+		var r *http.Request
+		select {
+		case r = <-requests:
+		case <-time.After(2 * time.Second):
+			t.Fatal("no request")
+		}
+		if resp := <-responses; resp == nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("response %v", resp)
+		}
+		s.Close()
+
+		if addr, ok := RemoteI2PAddr(r.Context()); !ok || addr != from {
+			t.Errorf("RemoteI2PAddr = %v, %v, want %v", addr, ok, from)
+		}
+		if v := r.Header.Get("X-I2P-DestSomething"); v != "" {
+			t.Errorf("client header kept: %q", v)
+		}
+		if !add {
+			for _, h := range []string{HeaderDestHash, HeaderDestB64, HeaderDestB32} {
+				if v := r.Header.Get(h); v != "" {
+					t.Errorf("%s = %q without AddHeaders", h, v)
+				}
+			}
+			continue
+		}
+		hash := from.DestHash()
+		want := map[string]string{
+			HeaderDestHash: i2pB64.EncodeToString(hash[:]),
+			HeaderDestB64:  from.Base64(),
+			HeaderDestB32:  from.Base32(),
+		}
+		for h, v := range want {
+			if got := r.Header.Values(h); len(got) != 1 || got[0] != v {
+				t.Errorf("%s = %q, want %q", h, got, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package sam3
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// Encryption types for keys generated with NewLocalKeysWithCrypto.
+const (
+	Crypto_ELGAMAL      = "CRYPTO_TYPE=ELGAMAL"
+	Crypto_ECIES_X25519 = "CRYPTO_TYPE=ECIES_X25519"
+)
+
+// parseCryptoType accepts one of the Crypto_* constants, a bare encryption
+// type name or its number. The empty string means ElGamal, like DEST GENERATE.
+func parseCryptoType(s string) (cryptoSpec, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "CRYPTO_TYPE="))
+	if s == "" {
+		return lookupCryptoSpec(0)
+	}
+	if code, err := strconv.Atoi(s); err == nil {
+		return lookupCryptoSpec(code)
+	}
+	for _, spec := range cryptoSpecs {
+		if strings.EqualFold(spec.name, s) {
+			return spec, nil
+		}
+	}
+	return cryptoSpec{}, errors.New("Unknown encryption type: " + s)
+}
+
+// NewLocalKeys creates new destination keys like SAM.NewKeys does, but
+// without a router: the keys are generated in this process. sigType is one of
+// EdDSA_SHA512_Ed25519 (the default), ECDSA_SHA256_P256, ECDSA_SHA384_P384
+// or ECDSA_SHA512_P521, in any form NewKeys accepts. The destination gets an
+// ElGamal encryption key, like the ones DEST GENERATE returns.
+func NewLocalKeys(sigType ...string) (i2pkeys.I2PKeys, error) {
+	sigtmp := ""
+	if len(sigType) > 0 {
+		sigtmp = sigType[0]
+	}
+	return NewLocalKeysWithCrypto(sigtmp, Crypto_ELGAMAL)
+}
+
+// NewLocalKeysWithCrypto is NewLocalKeys with a choice of encryption type,
+// Crypto_ELGAMAL or Crypto_ECIES_X25519.
+func NewLocalKeysWithCrypto(sigType, cryptoType string) (i2pkeys.I2PKeys, error) {
+	sig, err := parseSigType(sigType)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	crypto, err := parseCryptoType(cryptoType)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	p, err := newPrivateKeys(sig, crypto, nil)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	return p.Keys(), nil
+}
+
+// EnsureKeyfile is SAM.EnsureKeyfile without a router, new keys are made with
+// NewLocalKeys. This works before the router is up, for example while
+// provisioning or building.
+func EnsureKeyfile(fname string, sigType ...string) (keys i2pkeys.I2PKeys, err error) {
+	return ensureKeyfile(fname, func() (i2pkeys.I2PKeys, error) {
+		return NewLocalKeys(sigType...)
+	})
+}
+
+// loads keys from fname, or makes them with generate and stores them there if
+// the file does not exist. With an empty fname the keys are only generated.
+func ensureKeyfile(fname string, generate func() (i2pkeys.I2PKeys, error)) (keys i2pkeys.I2PKeys, err error) {
+	if fname == "" {
+		// transient
+		return generate()
+	}
+	// persistent
+	_, err = os.Stat(fname)
+	if os.IsNotExist(err) {
+		// make the keys
+		keys, err = generate()
+		if err == nil {
+			// save keys
+			var f io.WriteCloser
+			f, err = os.OpenFile(fname, os.O_WRONLY|os.O_CREATE, 0600)
+			if err == nil {
+				err = i2pkeys.StoreKeysIncompat(keys, f)
+				f.Close()
+			}
+		}
+	} else if err == nil {
+		// we haz key file
+		var f *os.File
+		f, err = os.Open(fname)
+		if err == nil {
+			keys, err = i2pkeys.LoadKeysIncompat(f)
+			f.Close()
+		}
+	}
+	return
+}
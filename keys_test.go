@@ -0,0 +1,157 @@
+package sam3
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+func Test_NewLocalKeys(t *testing.T) {
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := parsePrivateKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.dest.sig.name != "EdDSA_SHA512_Ed25519" || p.dest.crypto.name != "ELGAMAL" {
+		t.Errorf("default keys are %s/%s", p.dest.sig.name, p.dest.crypto.name)
+	}
+	for _, tt := range []struct{ sig, crypto, wantSig, wantCrypto string }{
+		{Sig_ECDSA_SHA256_P256, Crypto_ECIES_X25519, "ECDSA_SHA256_P256", "ECIES_X25519"},
+		{"ECDSA_SHA384_P384", "ELGAMAL", "ECDSA_SHA384_P384", "ELGAMAL"},
+		{"3", "4", "ECDSA_SHA512_P521", "ECIES_X25519"},
+	} {
+		keys, err := NewLocalKeysWithCrypto(tt.sig, tt.crypto)
+		if err != nil {
+			t.Fatalf("%s/%s: %v", tt.sig, tt.crypto, err)
+		}
+		p, err := parsePrivateKeys(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.dest.sig.name != tt.wantSig || p.dest.crypto.name != tt.wantCrypto {
+			t.Errorf("%s/%s: keys are %s/%s", tt.sig, tt.crypto, p.dest.sig.name, p.dest.crypto.name)
+		}
+	}
+	for _, tt := range []struct{ sig, crypto string }{
+		{"DSA_SHA1", ""},
+		{"RSA_SHA256_2048", ""},
+		{"", "CRYPTO_TYPE=MLKEM"},
+	} {
+		if _, err := NewLocalKeysWithCrypto(tt.sig, tt.crypto); err == nil {
+			t.Errorf("NewLocalKeysWithCrypto(%q, %q) did not fail", tt.sig, tt.crypto)
+		}
+	}
+}
+
+func Test_EnsureKeyfile(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "service.dat")
+	keys, err := EnsureKeyfile(fname, Sig_ECDSA_SHA256_P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("key file stored with mode %v", fi.Mode())
+	}
+	again, err := EnsureKeyfile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != keys {
+		t.Error("EnsureKeyfile did not load the stored keys")
+	}
+	transient, err := EnsureKeyfile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transient == keys {
+		t.Error("EnsureKeyfile without a file returned the stored keys")
+	}
+}
+
+// a private key blob laid out by hand from the common structures spec, the
+// way a router answers DEST GENERATE: the encryption key at the start of its
+// 256 bytes, the signing key at the end of its 128, padding between them,
+// the key certificate with any excess of the signing key, then the private
+// keys
+func fixtureKeys(encPub, sigPub int, cert []byte, encPriv, sigPriv int) []byte {
+	var b []byte
+	b = append(b, bytes.Repeat([]byte{0x11}, encPub)...)
+	b = append(b, bytes.Repeat([]byte{0x22}, keysAndCertLen-encPub-sigPub)...)
+	b = append(b, bytes.Repeat([]byte{0x33}, sigPub)...)
+	b = append(b, cert...)
+	b = append(b, bytes.Repeat([]byte{0x44}, encPriv)...)
+	b = append(b, bytes.Repeat([]byte{0x55}, sigPriv)...)
+	return b
+}
+
+func Test_KeyLayout(t *testing.T) {
+	for _, tt := range []struct {
+		sig, crypto string
+		blob        []byte
+		sigPub      []byte
+	}{
+		{
+			"EdDSA_SHA512_Ed25519", "ELGAMAL",
+			fixtureKeys(256, 32, []byte{5, 0, 4, 0, 7, 0, 0}, 256, 32),
+			bytes.Repeat([]byte{0x33}, 32),
+		},
+		{
+			"ECDSA_SHA256_P256", "ECIES_X25519",
+			fixtureKeys(32, 64, []byte{5, 0, 4, 0, 1, 0, 4}, 32, 32),
+			bytes.Repeat([]byte{0x33}, 64),
+		},
+		{
+			// 132 bytes of key, the last 4 in the certificate
+			"ECDSA_SHA512_P521", "ELGAMAL",
+			fixtureKeys(256, 128, []byte{5, 0, 8, 0, 3, 0, 0, 0x66, 0x66, 0x66, 0x66}, 256, 66),
+			append(bytes.Repeat([]byte{0x33}, 128), 0x66, 0x66, 0x66, 0x66),
+		},
+	} {
+		fixture, err := parsePrivateKeys(i2pkeys.NewKeys("", i2pB64.EncodeToString(tt.blob)))
+		if err != nil {
+			t.Fatalf("%s/%s: %v", tt.sig, tt.crypto, err)
+		}
+		if fixture.dest.sig.name != tt.sig || fixture.dest.crypto.name != tt.crypto {
+			t.Errorf("%s/%s: fixture read as %s/%s", tt.sig, tt.crypto, fixture.dest.sig.name, fixture.dest.crypto.name)
+		}
+		if !bytes.Equal(fixture.dest.sigPub, tt.sigPub) || !bytes.Equal(fixture.dest.encPub, tt.blob[:len(fixture.dest.encPub)]) {
+			t.Errorf("%s/%s: public keys read from the wrong place", tt.sig, tt.crypto)
+		}
+		if !isZero(bytes.Trim(fixture.encPriv, "\x44")) || !isZero(bytes.Trim(fixture.sigPriv, "\x55")) {
+			t.Errorf("%s/%s: private keys read from the wrong place", tt.sig, tt.crypto)
+		}
+		if fixture.String() != i2pB64.EncodeToString(tt.blob) {
+			t.Errorf("%s/%s: fixture does not round-trip", tt.sig, tt.crypto)
+		}
+
+		sig, _ := parseSigType(tt.sig)
+		crypto, _ := parseCryptoType(tt.crypto)
+		p, err := newPrivateKeys(sig, crypto, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		local, _ := i2pB64.DecodeString(p.String())
+		if len(local) != len(tt.blob) || len(p.dest.raw) != len(fixture.dest.raw) {
+			t.Errorf("%s/%s: local keys of %d bytes with a %d byte destination, want %d and %d",
+				tt.sig, tt.crypto, len(local), len(p.dest.raw), len(tt.blob), len(fixture.dest.raw))
+			continue
+		}
+		cert := tt.blob[keysAndCertLen : keysAndCertLen+7]
+		if !bytes.Equal(local[keysAndCertLen:keysAndCertLen+7], cert) {
+			t.Errorf("%s/%s: local certificate %x, want %x", tt.sig, tt.crypto, local[keysAndCertLen:keysAndCertLen+7], cert)
+		}
+		if len(p.dest.padding()) != len(fixture.dest.padding()) {
+			t.Errorf("%s/%s: %d bytes of padding, want %d", tt.sig, tt.crypto, len(p.dest.padding()), len(fixture.dest.padding()))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package sam3
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// Done returns a channel that is closed once the session is closed, with
+// everything of it.
+func (s *StreamSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Shutdown closes the session gracefully. Dials and accepts in progress are
+// interrupted, new ones fail with net.ErrClosed, and the session is closed
+// once its connections are, or when ctx is done. Then the ones still open are
+// closed, and ctx.Err() is returned.
+func (s *StreamSession) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.draining {
+		s.draining = true
+		s.idle = make(chan struct{})
+	}
+	idle := s.idle
+	var pending []io.Closer
+	for c := range s.children {
+		if _, ok := c.(*SAMConn); !ok {
+			pending = append(pending, c)
+		}
+	}
+	s.checkIdle()
+	s.mu.Unlock()
+	for _, c := range pending {
+		c.Close()
+	}
+	var err error
+	select {
+	case <-idle:
+	case <-s.stop:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// adds c to what the session closes with itself. It fails with
+// net.ErrClosed if the session is closed or draining.
+func (s *StreamSession) track(c io.Closer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stop:
+		return net.ErrClosed
+	default:
+	}
+	if s.draining {
+		return net.ErrClosed
+	}
+	if s.children == nil {
+		s.children = make(map[io.Closer]struct{})
+	}
+	s.children[c] = struct{}{}
+	return nil
+}
+
+// removes c from what the session closes with itself
+func (s *StreamSession) untrack(c io.Closer) {
+	s.mu.Lock()
+	delete(s.children, c)
+	s.checkIdle()
+	s.mu.Unlock()
+}
+
+// closes idle if the session is draining and has no connections left.
+// Called with mu held.
+func (s *StreamSession) checkIdle() {
+	if !s.draining {
+		return
+	}
+	select {
+	case <-s.idle:
+		return
+	default:
+	}
+	for c := range s.children {
+		if _, ok := c.(*SAMConn); ok {
+			return
+		}
+	}
+	close(s.idle)
+}
+
+// reports whether the session is closed or draining
+func (s *StreamSession) closing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return s.draining
+	}
+}
+
+// returns net.ErrClosed instead of err if the session went away, which is
+// why a dial or accept failed then
+func (s *StreamSession) closedErr(err error) error {
+	if s.closing() {
+		return net.ErrClosed
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newLifecycleSession(t *testing.T) (*fakeBridge, *StreamSession) {
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	sam, err := NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSession("", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, ss
+}
+
+func Test_SessionCloseClosesChildren(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	b.idle = true
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.AcceptI2P()
+		accepted <- err
+	}()
+	for b.count("STREAM ACCEPT") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := ss.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("pending accept: %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("accept still pending after Close")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("dialed connection still open after Close")
+	}
+	select {
+	case <-ss.Done():
+	default:
+		t.Error("Done not closed after Close")
+	}
+	if err := ss.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := ss.DialI2P(ss.Addr()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("dial after Close: %v, want net.ErrClosed", err)
+	}
+	if _, err := l.AcceptI2P(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("accept after Close: %v, want net.ErrClosed", err)
+	}
+}
+
+func Test_SessionShutdown(t *testing.T) {
+	_, ss := newLifecycleSession(t)
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	shut := make(chan error, 1)
+	go func() { shut <- ss.Shutdown(context.Background()) }()
+	for !ss.closing() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := ss.DialI2P(ss.Addr()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("dial while draining: %v, want net.ErrClosed", err)
+	}
+	select {
+	case <-ss.Done():
+		t.Fatal("session closed with a connection open")
+	case <-time.After(20 * time.Millisecond):
+	}
+	conn.Close()
+	if err := <-shut; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	<-ss.Done()
+
+	_, ss = newLifecycleSession(t)
+	conn, err = ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ss.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown with a connection open: %v, want context.DeadlineExceeded", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open after Shutdown timed out")
+	}
+}
+
+func Test_ListenerClose(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	b.mu.Lock()
+	b.idle = true
+	b.mu.Unlock()
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.AcceptI2P()
+		accepted <- err
+	}()
+	for b.count("STREAM ACCEPT") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	l.Close()
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("pending accept: %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("accept still pending after the listener was closed")
+	}
+	if _, err := l.AcceptI2P(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("accept on a closed listener: %v, want net.ErrClosed", err)
+	}
+
+	// the session and its other listener are still there
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatalf("dial after closing a listener: %v", err)
+	}
+	conn.Close()
+	b.mu.Lock()
+	b.idle = false
+	b.mu.Unlock()
+	conn, err = other.AcceptI2P()
+	if err != nil {
+		t.Fatalf("accept on the other listener: %v", err)
+	}
+	conn.Close()
+
+	ss.Close()
+	if _, err := other.AcceptI2P(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("accept after the session was closed: %v, want net.ErrClosed", err)
+	}
+	if !other.isClosed() {
+		t.Error("session did not close its listener")
+	}
+}
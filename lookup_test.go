@@ -0,0 +1,63 @@
+package sam3
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+func Test_LookupReuse(t *testing.T) {
+	fb, ss := newLifecycleSession(t)
+	a, b := newTestAddr(t), newTestAddr(t)
+	fb.mu.Lock()
+	fb.names = map[string]i2pkeys.I2PAddr{"a.i2p": a, "b.i2p": b}
+	fb.mu.Unlock()
+
+	for _, name := range []string{"a.i2p", "b.i2p", "missing.i2p", "a.i2p"} {
+		addr, err := ss.Lookup(name)
+		if name == "missing.i2p" {
+			if !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("lookup of %s: %v, want ErrKeyNotFound", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.Base32() != fb.names[name].Base32() {
+			t.Errorf("%s resolved to %s", name, addr.Base32())
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ss.Lookup("b.i2p"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fb.count("HELLO VERSION"); n != 2 {
+		// the session and the lookups
+		t.Errorf("bridge got %d connections, want 2", n)
+	}
+	if n := fb.count("NAMING LOOKUP"); n != 14 {
+		t.Errorf("bridge got %d lookups, want 14", n)
+	}
+
+	ss.Close()
+	ss.mu.Lock()
+	resolver := ss.resolver
+	ss.mu.Unlock()
+	if resolver != nil {
+		t.Error("session kept its lookup connection after Close")
+	}
+	if _, err := ss.Lookup("a.i2p"); err == nil {
+		t.Error("lookup on a closed session")
+	}
+}
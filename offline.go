@@ -0,0 +1,231 @@
+package sam3
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// The offline signature section of a SAM 3.3 private key. It follows the
+// zeroed signing private key and lets a router run a destination with a
+// short-lived transient key, signed by a master key that is kept elsewhere.
+type offlineSection struct {
+	expires       uint32
+	transient     sigSpec
+	transientPub  []byte
+	signature     []byte
+	transientPriv []byte
+}
+
+func parseOfflineSection(b []byte, destSig sigSpec) (*offlineSection, error) {
+	if len(b) < 6 {
+		return nil, errors.New("Signing key is zero but the offline signature is missing")
+	}
+	o := &offlineSection{expires: binary.BigEndian.Uint32(b)}
+	var err error
+	if o.transient, err = lookupSigSpec(int(binary.BigEndian.Uint16(b[4:]))); err != nil {
+		return nil, err
+	}
+	b = b[6:]
+	if len(b) < o.transient.pubLen+destSig.sigLen+o.transient.privLen {
+		return nil, errors.New("Offline signature truncated")
+	}
+	o.transientPub = b[:o.transient.pubLen]
+	b = b[o.transient.pubLen:]
+	o.signature = b[:destSig.sigLen]
+	o.transientPriv = b[destSig.sigLen : destSig.sigLen+o.transient.privLen]
+	return o, nil
+}
+
+// the part of the section that the master key signs
+func (o *offlineSection) signed() []byte {
+	b := make([]byte, 6, 6+len(o.transientPub))
+	binary.BigEndian.PutUint32(b, o.expires)
+	binary.BigEndian.PutUint16(b[4:], uint16(o.transient.code))
+	return append(b, o.transientPub...)
+}
+
+func (o *offlineSection) bytes() []byte {
+	var b bytes.Buffer
+	b.Write(o.signed())
+	b.Write(o.signature)
+	b.Write(o.transientPriv)
+	return b.Bytes()
+}
+
+func (o *offlineSection) Expires() time.Time {
+	return time.Unix(int64(o.expires), 0)
+}
+
+// NewOfflineKeys turns the master keys of a destination into offline-signed
+// keys for a router. A transient signing key of type transientSigType (one of
+// the Sig_* constants, EdDSA_SHA512_Ed25519 by default) is generated and
+// signed by the master signing key; the returned keys carry the transient key
+// instead of the master signing key and are valid until expires.
+//
+// Run this where the master keys are kept, and only copy the result to the
+// server. The keys can be stored with i2pkeys.StoreKeysIncompat and used like
+// any other keys, they need a SAM 3.3 capable router.
+func NewOfflineKeys(master i2pkeys.I2PKeys, expires time.Time, transientSigType ...string) (i2pkeys.I2PKeys, error) {
+	p, err := parsePrivateKeys(master)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	if p.offline != nil {
+		return i2pkeys.I2PKeys{}, errors.New("Keys are offline-signed already, need the master keys")
+	}
+	if !expires.After(time.Now()) {
+		return i2pkeys.I2PKeys{}, errors.New("Offline signature would be expired already")
+	}
+	sigtmp := ""
+	if len(transientSigType) > 0 {
+		sigtmp = transientSigType[0]
+	}
+	spec, err := parseSigType(sigtmp)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	o := &offlineSection{expires: uint32(expires.Unix()), transient: spec}
+	if o.transientPub, o.transientPriv, err = generateSigningKey(spec, nil); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	if o.signature, err = sign(p.dest.sig, p.sigPriv, o.signed()); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	p.sigPriv = make([]byte, len(p.sigPriv))
+	p.offline = o
+	return i2pkeys.NewKeys(master.Addr(), p.String()), nil
+}
+
+// OfflineExpires returns when the offline signature of keys expires. ok is
+// false if the keys are not offline-signed.
+func OfflineExpires(keys i2pkeys.I2PKeys) (expires time.Time, ok bool) {
+	p, err := parsePrivateKeys(keys)
+	if err != nil || p.offline == nil {
+		return time.Time{}, false
+	}
+	return p.offline.Expires(), true
+}
+
+// VerifyOfflineKeys checks that the offline signature of keys was made by the
+// master key of their destination and has not expired.
+func VerifyOfflineKeys(keys i2pkeys.I2PKeys) error {
+	p, err := parsePrivateKeys(keys)
+	if err != nil {
+		return err
+	}
+	if p.offline == nil {
+		return errors.New("Keys are not offline-signed")
+	}
+	if !verify(p.dest.sig, p.dest.sigPub, p.offline.signed(), p.offline.signature) {
+		return errors.New("Offline signature does not match the destination")
+	}
+	if !p.offline.Expires().After(time.Now()) {
+		return errors.New("Offline signature expired at " + p.offline.Expires().String())
+	}
+	return nil
+}
+
+// OfflineExpires returns when the transient signing key of the session expires.
+// ok is false if the session does not use offline-signed keys.
+func (ss *StreamSession) OfflineExpires() (expires time.Time, ok bool) {
+	return OfflineExpires(ss.Keys())
+}
+
+// WatchOfflineExpiry warns margin before the transient signing key of the
+// session expires. If refresh is not nil it is then called for new
+// offline-signed keys of the same destination, and the session is re-created
+// with them under the same tunnel name. It does nothing for sessions that do
+// not use offline keys, and stops when the session is closed.
+func (ss *StreamSession) WatchOfflineExpiry(margin time.Duration, refresh func() (i2pkeys.I2PKeys, error)) {
+	expires, ok := ss.OfflineExpires()
+	if !ok {
+		return
+	}
+	go func() {
+		next := expires.Add(-margin)
+		for {
+			t := time.NewTimer(time.Until(next))
+			select {
+			case <-ss.stop:
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			log.Printf("sam3: offline signature of %s expires at %s", ss.Addr().Base32(), expires)
+			if refresh == nil {
+				return
+			}
+			keys, err := refresh()
+			if err == nil {
+				err = ss.rekey(keys)
+			}
+			if err != nil {
+				log.Printf("sam3: refreshing offline keys of %s failed: %s", ss.Addr().Base32(), err)
+				left := time.Until(expires)
+				if left <= 0 {
+					return
+				}
+				// try again halfway to the expiry
+				if left < 2*time.Second {
+					left = 2 * time.Second
+				}
+				next = time.Now().Add(left / 2)
+				continue
+			}
+			expires, _ = ss.OfflineExpires()
+			next = expires.Add(-margin)
+		}
+	}()
+}
+
+// re-creates the session with new offline-signed keys for the same
+// destination. If that fails the old session is gone as well, so ss is
+// closed.
+func (ss *StreamSession) rekey(keys i2pkeys.I2PKeys) error {
+	if keys.Addr() != ss.Addr() {
+		return errors.New("Refreshed keys are for a different destination")
+	}
+	if err := VerifyOfflineKeys(keys); err != nil {
+		return err
+	}
+	sam, err := ss.newSAM(context.Background())
+	if err != nil {
+		return err
+	}
+	ss.renew.Lock()
+	defer ss.renew.Unlock()
+	// Close interrupts the SESSION CREATE, which can take minutes
+	if err := ss.track(sam.conn); err != nil {
+		sam.Close()
+		return err
+	}
+	defer ss.untrack(sam.conn)
+	ss.mu.Lock()
+	old, id, from, to, sigType, options := ss.conn, ss.id, ss.from, ss.to, ss.sigType, ss.options
+	ss.mu.Unlock()
+	// the router refuses a second session with the same name and destination
+	old.Close()
+	conn, _, err := sam.createSession(context.Background(), "STREAM", id, from, to, keys, sigType, options, []string{})
+	if err != nil {
+		ss.Close()
+		return ss.closedErr(err)
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	select {
+	case <-ss.stop:
+		conn.Close()
+		return net.ErrClosed
+	default:
+	}
+	ss.conn = conn
+	ss.keys = keys
+	return nil
+}
@@ -0,0 +1,230 @@
+package sam3
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+func Test_KeyWireFormat(t *testing.T) {
+	for _, sig := range sigSpecs {
+		if sig.code == 0 {
+			// DSA keys can not be made locally
+			continue
+		}
+		for _, crypto := range cryptoSpecs {
+			p, err := newPrivateKeys(sig, crypto, nil)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", sig.name, crypto.name, err)
+			}
+			keys := p.Keys()
+			q, err := parsePrivateKeys(keys)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", sig.name, crypto.name, err)
+			}
+			if q.dest.sig != sig || q.dest.crypto != crypto {
+				t.Errorf("%s/%s: read back as %s/%s", sig.name, crypto.name, q.dest.sig.name, q.dest.crypto.name)
+			}
+			if len(q.dest.sigPub) != sig.pubLen || len(q.dest.encPub) != crypto.pubLen {
+				t.Errorf("%s/%s: public keys of %d and %d bytes", sig.name, crypto.name, len(q.dest.sigPub), len(q.dest.encPub))
+			}
+			if !bytes.Equal(q.dest.sigPub, p.dest.sigPub) || !bytes.Equal(q.sigPriv, p.sigPriv) || !bytes.Equal(q.encPriv, p.encPriv) {
+				t.Errorf("%s/%s: keys changed on the way", sig.name, crypto.name)
+			}
+			if q.String() != keys.String() {
+				t.Errorf("%s/%s: String does not round-trip", sig.name, crypto.name)
+			}
+			msg := []byte("offline signature")
+			s, err := sign(sig, q.sigPriv, msg)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", sig.name, crypto.name, err)
+			}
+			if !verify(sig, q.dest.sigPub, msg, s) || verify(sig, q.dest.sigPub, []byte("something else"), s) {
+				t.Errorf("%s/%s: signature does not verify against the destination", sig.name, crypto.name)
+			}
+			addr, err := i2pkeys.NewI2PAddrFromString(string(keys.Addr()))
+			if err != nil || addr.Base32() != keys.Addr().Base32() {
+				t.Errorf("%s/%s: destination %v does not parse: %v", sig.name, crypto.name, keys.Addr(), err)
+			}
+		}
+	}
+	if _, err := parsePrivateKeys(i2pkeys.NewKeys("", i2pB64.EncodeToString(make([]byte, 100)))); err == nil {
+		t.Error("parsePrivateKeys accepted a truncated destination")
+	}
+}
+
+func Test_OfflineKeys(t *testing.T) {
+	for _, transient := range []string{"", Sig_ECDSA_SHA256_P256} {
+		master, err := NewLocalKeys(Sig_EdDSA_SHA512_Ed25519)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expires := time.Now().Add(time.Hour).Truncate(time.Second)
+		keys, err := NewOfflineKeys(master, expires, transient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys.Addr() != master.Addr() {
+			t.Errorf("offline keys are for %s, want %s", keys.Addr().Base32(), master.Addr().Base32())
+		}
+		if err := VerifyOfflineKeys(keys); err != nil {
+			t.Error(err)
+		}
+		if got, ok := OfflineExpires(keys); !ok || !got.Equal(expires) {
+			t.Errorf("OfflineExpires = %s, %v, want %s", got, ok, expires)
+		}
+		p, err := parsePrivateKeys(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isZero(p.sigPriv) || p.offline == nil {
+			t.Fatal("offline keys still carry the master signing key")
+		}
+		if p.String() != keys.String() {
+			t.Error("String does not round-trip offline keys")
+		}
+		if _, err := NewOfflineKeys(keys, expires); err == nil {
+			t.Error("NewOfflineKeys signed with offline keys")
+		}
+
+		// a signature by another master key
+		other, err := NewLocalKeys(Sig_EdDSA_SHA512_Ed25519)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, _ := parsePrivateKeys(other)
+		p.offline.signature, _ = sign(q.dest.sig, q.sigPriv, p.offline.signed())
+		if err := VerifyOfflineKeys(i2pkeys.NewKeys(keys.Addr(), p.String())); err == nil {
+			t.Error("VerifyOfflineKeys accepted a signature of another key")
+		}
+	}
+	master, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewOfflineKeys(master, time.Now().Add(-time.Minute)); err == nil {
+		t.Error("NewOfflineKeys made keys that are expired already")
+	}
+}
+
+// keys whose offline signature is valid but expired at expires
+func expiredOfflineKeys(t *testing.T, expires time.Time) i2pkeys.I2PKeys {
+	master, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewOfflineKeys(master, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := parsePrivateKeys(master)
+	p, _ := parsePrivateKeys(keys)
+	p.offline.expires = uint32(expires.Unix())
+	p.offline.signature, _ = sign(m.dest.sig, m.sigPriv, p.offline.signed())
+	return i2pkeys.NewKeys(keys.Addr(), p.String())
+}
+
+func Test_ExpiredOfflineKeys(t *testing.T) {
+	keys := expiredOfflineKeys(t, time.Now().Add(-time.Minute))
+	if err := VerifyOfflineKeys(keys); err == nil {
+		t.Error("VerifyOfflineKeys accepted expired keys")
+	}
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	sam, err := NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewStreamSession("expired", keys, nil); err == nil {
+		t.Error("session created with expired offline keys")
+	}
+	if n := b.count("SESSION CREATE"); n != 0 {
+		t.Errorf("expired keys were sent to the bridge %d times", n)
+	}
+}
+
+func Test_OfflineRekey(t *testing.T) {
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	master, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offline := func() i2pkeys.I2PKeys {
+		keys, err := NewOfflineKeys(master, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+	session := func() *StreamSession {
+		sam, err := NewSAM(b.l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ss, err := sam.NewStreamSession("", offline(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ss
+	}
+
+	ss := session()
+	keys := offline()
+	if err := ss.rekey(keys); err != nil {
+		t.Fatal(err)
+	}
+	if ss.Keys().String() != keys.String() {
+		t.Error("session kept its old keys")
+	}
+	ss.Close()
+
+	// the router refuses the new session, the old one is gone already
+	ss = session()
+	b.mu.Lock()
+	b.dupIDs = 1
+	b.mu.Unlock()
+	if err := ss.rekey(offline()); err == nil {
+		t.Error("rekey did not fail")
+	}
+	select {
+	case <-ss.Done():
+	default:
+		t.Error("session not closed after a failed rekey")
+	}
+
+	// the router takes its time, the session stays usable and can be closed
+	ss = session()
+	b.mu.Lock()
+	b.stall = true
+	b.mu.Unlock()
+	errc := make(chan error, 1)
+	go func() { errc <- ss.rekey(offline()) }()
+	for deadline := time.Now().Add(2 * time.Second); b.count("SESSION CREATE") < 6; {
+		if time.Now().After(deadline) {
+			t.Fatal("rekey did not create a session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	done := make(chan struct{})
+	go func() {
+		ss.Keys()
+		ss.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("session blocked during a rekey")
+	}
+	select {
+	case err := <-errc:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("rekey of a closed session: %v, want net.ErrClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not end the rekey")
+	}
+}
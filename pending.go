@@ -0,0 +1,80 @@
+package sam3
+
+import (
+	"context"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// NewStreamSessionContext is NewStreamSession, but gives up when ctx is done
+// before the router has built the tunnels, which can take minutes. The
+// connection to the bridge is closed then, and with it the half-built session.
+func (sam *SAM) NewStreamSessionContext(ctx context.Context, id string, keys i2pkeys.I2PKeys, options []string) (*StreamSession, error) {
+	return sam.createStreamSession(ctx, id, "0", "0", keys, Sig_NONE, options)
+}
+
+// PendingSession is a stream session that is still being created, see
+// StartStreamSession.
+type PendingSession struct {
+	cancel  context.CancelFunc
+	ready   chan struct{}
+	started time.Time
+	// set before ready is closed
+	ss   *StreamSession
+	err  error
+	took time.Duration
+}
+
+// StartStreamSession creates a stream session like NewStreamSessionContext,
+// but returns right away. The session is there once Ready is closed, unless
+// Err says otherwise.
+func (sam *SAM) StartStreamSession(ctx context.Context, id string, keys i2pkeys.I2PKeys, options []string) *PendingSession {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &PendingSession{cancel: cancel, ready: make(chan struct{}), started: time.Now()}
+	go func() {
+		defer cancel()
+		p.ss, p.err = sam.NewStreamSessionContext(ctx, id, keys, options)
+		p.took = time.Since(p.started)
+		close(p.ready)
+	}()
+	return p
+}
+
+// Ready is closed once the session was created or failed to be.
+func (p *PendingSession) Ready() <-chan struct{} {
+	return p.ready
+}
+
+// Err returns why the session could not be created, nil while it is still
+// being created and when it was.
+func (p *PendingSession) Err() error {
+	select {
+	case <-p.ready:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// Session waits until Ready and returns the session, or why there is none.
+func (p *PendingSession) Session() (*StreamSession, error) {
+	<-p.ready
+	return p.ss, p.err
+}
+
+// Elapsed returns how long the session has been in the making, or took.
+func (p *PendingSession) Elapsed() time.Duration {
+	select {
+	case <-p.ready:
+		return p.took
+	default:
+		return time.Since(p.started)
+	}
+}
+
+// Cancel stops creating the session. A session that is already there is not
+// closed, Session returns it as usual.
+func (p *PendingSession) Cancel() {
+	p.cancel()
+}
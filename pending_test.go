@@ -0,0 +1,82 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_NewStreamSessionContext(t *testing.T) {
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	b.stall = true
+	sam, err := NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := sam.NewStreamSessionContext(ctx, "stalled", keys, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("session on a stalled bridge: %v, want context.DeadlineExceeded", err)
+	}
+	for i := 0; ; i++ {
+		b.mu.Lock()
+		dropped := b.dropped
+		b.mu.Unlock()
+		if dropped == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the bridge still has the half-built session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_StartStreamSession(t *testing.T) {
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	b.stall = true
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sam, err := NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := sam.StartStreamSession(context.Background(), "pending", keys, nil)
+	select {
+	case <-p.Ready():
+		t.Fatal("session ready on a stalled bridge")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if p.Err() != nil {
+		t.Errorf("pending session has error %v", p.Err())
+	}
+	p.Cancel()
+	<-p.Ready()
+	if _, err := p.Session(); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled session: %v, want context.Canceled", err)
+	}
+
+	b.mu.Lock()
+	b.stall = false
+	b.mu.Unlock()
+	sam, err = NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = sam.StartStreamSession(context.Background(), "pending", keys, nil)
+	ss, err := p.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if p.Err() != nil || ss.Keys() != keys {
+		t.Errorf("session has keys %v and error %v", ss.Keys(), p.Err())
+	}
+}
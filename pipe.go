@@ -0,0 +1,104 @@
+package sam3
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pipe copies between a and b in both directions, for proxies and tunnels.
+// When one side is done sending, the other side's writing half is closed, if
+// it can be half-closed, so the other direction still gets through. If idle is
+// not zero, both are given up on when nothing went either way for that long.
+// Pipe returns when both directions are done and closes a and b. It returns
+// the bytes copied from a to b and from b to a, and the first error other than
+// EOF.
+func Pipe(a, b net.Conn, idle time.Duration) (aToB, bToA int64, err error) {
+	p := &pipe{idle: idle}
+	p.touch()
+	var wg sync.WaitGroup
+	var errAB, errBA error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		aToB, errAB = p.copy(b, a)
+		p.done(b, errAB)
+	}()
+	go func() {
+		defer wg.Done()
+		bToA, errBA = p.copy(a, b)
+		p.done(a, errBA)
+	}()
+	wg.Wait()
+	a.Close()
+	b.Close()
+	if errAB != nil {
+		return aToB, bToA, errAB
+	}
+	return aToB, bToA, errBA
+}
+
+type pipe struct {
+	idle time.Duration
+	// unix nanoseconds of the last read or write in either direction
+	last int64
+}
+
+func (p *pipe) touch() {
+	atomic.StoreInt64(&p.last, time.Now().UnixNano())
+}
+
+// signals the end of the data to dst, or closes it if that fails
+func (p *pipe) done(dst net.Conn, err error) {
+	if err == nil {
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+			return
+		}
+	}
+	dst.Close()
+}
+
+func (p *pipe) copy(dst, src net.Conn) (int64, error) {
+	if p.idle == 0 {
+		n, err := io.Copy(dst, src)
+		return n, quiet(err)
+	}
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		src.SetReadDeadline(time.Now().Add(p.idle))
+		n, err := src.Read(buf)
+		if n > 0 {
+			p.touch()
+			dst.SetWriteDeadline(time.Now().Add(p.idle))
+			m, werr := dst.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, quiet(werr)
+			}
+			p.touch()
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// only idle if the other direction was idle too
+			if time.Since(time.Unix(0, atomic.LoadInt64(&p.last))) < p.idle {
+				continue
+			}
+			return written, err
+		}
+		if err != nil {
+			return written, quiet(err)
+		}
+	}
+}
+
+// EOF and closing by the other direction are how copying ends
+func quiet(err error) error {
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package sam3
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := <-accepted
+	if s == nil {
+		t.Fatal("accept failed")
+	}
+	return c.(*net.TCPConn), s.(*net.TCPConn)
+}
+
+type pipeResult struct {
+	aToB, bToA int64
+	err        error
+}
+
+func startPipe(a, b net.Conn, idle time.Duration) chan pipeResult {
+	done := make(chan pipeResult, 1)
+	go func() {
+		aToB, bToA, err := Pipe(a, b, idle)
+		done <- pipeResult{aToB, bToA, err}
+	}()
+	return done
+}
+
+func Test_PipeHalfClose(t *testing.T) {
+	client, a := tcpPair(t)
+	b, server := tcpPair(t)
+	done := startPipe(a, b, 0)
+
+	client.Write([]byte("request"))
+	client.CloseWrite()
+	req, err := io.ReadAll(server)
+	if err != nil || string(req) != "request" {
+		t.Fatalf("server read %q, %v", req, err)
+	}
+	// the other direction still works after the client is done sending
+	server.Write([]byte("response!"))
+	server.Close()
+	resp, err := io.ReadAll(client)
+	if err != nil || string(resp) != "response!" {
+		t.Fatalf("client read %q, %v", resp, err)
+	}
+	client.Close()
+	r := <-done
+	if r.aToB != 7 || r.bToA != 9 || r.err != nil {
+		t.Errorf("Pipe = %d, %d, %v, want 7, 9, nil", r.aToB, r.bToA, r.err)
+	}
+}
+
+func Test_PipeIdle(t *testing.T) {
+	client, a := tcpPair(t)
+	b, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+	done := startPipe(a, b, 100*time.Millisecond)
+
+	// only the client talks, which keeps the quiet server side open too
+	for i := 0; i < 8; i++ {
+		client.Write([]byte("x"))
+		time.Sleep(40 * time.Millisecond)
+		select {
+		case r := <-done:
+			t.Fatalf("Pipe gave up while one side was busy: %v", r.err)
+		default:
+		}
+	}
+	select {
+	case r := <-done:
+		if !errors.Is(r.err, os.ErrDeadlineExceeded) || r.aToB != 8 || r.bToA != 0 {
+			t.Errorf("idle Pipe = %d, %d, %v, want 8, 0, deadline exceeded", r.aToB, r.bToA, r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle Pipe did not end")
+	}
+}
+
+func Test_SAMConnHalfClose(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	b.mu.Lock()
+	b.peer = func(c net.Conn) { io.Copy(c, c) }
+	b.mu.Unlock()
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("echo"))
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "echo" {
+		t.Errorf("read %q, %v after CloseWrite, want the echo", got, err)
+	}
+}
+
+func Test_AcceptEarlyData(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	b.mu.Lock()
+	b.early = "hello "
+	b.mu.Unlock()
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("world"))
+	if err := conn.(*SAMConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "hello world" {
+		t.Errorf("read %q, %v, want the early data first", got, err)
+	}
+}
@@ -0,0 +1,160 @@
+package sam3
+
+import (
+	"sync"
+	"time"
+)
+
+// the longest a limited Read or Write sleeps before looking at the limit
+// again, so changing it takes effect on connections that are waiting
+const maxThrottleSleep = 100 * time.Millisecond
+
+// smallest burst a limit gets by default, about a streaming packet or three
+const minBurst = 4096
+
+// RateLimiter is a token bucket limiting the bytes per second going through
+// SAMConns. Each connection has one per direction and so does each
+// StreamSession, for the sum of its connections. The limit can be changed at
+// any time. A zero limit, the default, lets everything through but still
+// counts the bytes.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      int
+	burst     int
+	tokens    float64
+	last      time.Time
+	total     uint64
+	throttled time.Duration
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSec, see SetLimit.
+func NewRateLimiter(bytesPerSec, burst int) *RateLimiter {
+	r := &RateLimiter{}
+	r.SetLimit(bytesPerSec, burst)
+	return r
+}
+
+// SetLimit sets the rate in bytes per second, 0 for no limit. burst is how
+// many bytes may go through at once after a quiet time, if less than 1 it is
+// a second's worth.
+func (r *RateLimiter) SetLimit(bytesPerSec, burst int) {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	if burst < 1 {
+		burst = bytesPerSec
+	}
+	if burst < minBurst {
+		burst = minBurst
+	}
+	r.mu.Lock()
+	r.refill(time.Now())
+	if r.rate == 0 {
+		// start out with a full bucket
+		r.tokens = float64(burst)
+	}
+	r.rate, r.burst = bytesPerSec, burst
+	if r.tokens > float64(burst) {
+		r.tokens = float64(burst)
+	}
+	r.mu.Unlock()
+}
+
+// Limit returns the rate in bytes per second and the burst.
+func (r *RateLimiter) Limit() (bytesPerSec, burst int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rate, r.burst
+}
+
+// Bytes returns how many bytes went through.
+func (r *RateLimiter) Bytes() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total
+}
+
+// Throttled returns how long Reads and Writes waited for the limit in total.
+func (r *RateLimiter) Throttled() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.throttled
+}
+
+// adds the tokens earned since the last call
+func (r *RateLimiter) refill(now time.Time) {
+	if r.rate > 0 && !r.last.IsZero() {
+		r.tokens += now.Sub(r.last).Seconds() * float64(r.rate)
+		if r.tokens > float64(r.burst) {
+			r.tokens = float64(r.burst)
+		}
+	}
+	r.last = now
+}
+
+// chunk returns how much of n bytes to move at once
+func (r *RateLimiter) chunk(n int) int {
+	if r == nil {
+		return n
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rate > 0 && n > r.burst {
+		return r.burst
+	}
+	return n
+}
+
+// unlimited reports whether the limiter lets everything through right now
+func (r *RateLimiter) unlimited() bool {
+	if r == nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rate == 0
+}
+
+// count counts n bytes that went through without asking the limit
+func (r *RateLimiter) count(n int64) {
+	if r == nil || n <= 0 {
+		return
+	}
+	r.mu.Lock()
+	r.total += uint64(n)
+	r.mu.Unlock()
+}
+
+// take counts n bytes and sleeps until the limit allows them. The bucket can
+// go into debt, the next caller then waits for it to be paid off. It sleeps
+// instead of spinning so other goroutines run meanwhile, which matters with
+// the single thread of wasip1.
+func (r *RateLimiter) take(n int) {
+	if r == nil || n <= 0 {
+		return
+	}
+	r.mu.Lock()
+	r.total += uint64(n)
+	if r.rate == 0 {
+		r.mu.Unlock()
+		return
+	}
+	r.refill(time.Now())
+	r.tokens -= float64(n)
+	for r.rate > 0 && r.tokens < 0 {
+		d := time.Duration(-r.tokens / float64(r.rate) * float64(time.Second))
+		if d > maxThrottleSleep {
+			d = maxThrottleSleep
+		}
+		r.mu.Unlock()
+		time.Sleep(d)
+		r.mu.Lock()
+		r.throttled += d
+		r.refill(time.Now())
+	}
+	if r.rate == 0 {
+		// the limit was lifted while waiting, forget the debt
+		r.tokens = 0
+	}
+	r.mu.Unlock()
+}
@@ -0,0 +1,143 @@
+package sam3
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a connection whose ReadFrom and WriteTo record that they were used
+type fastConn struct {
+	net.Conn
+	readFrom, writeTo bool
+}
+
+func (c *fastConn) ReadFrom(r io.Reader) (int64, error) {
+	c.readFrom = true
+	return io.Copy(c.Conn, r)
+}
+
+func (c *fastConn) WriteTo(w io.Writer) (int64, error) {
+	c.writeTo = true
+	return io.Copy(w, c.Conn)
+}
+
+func Test_SAMConnFastPath(t *testing.T) {
+	ss := &StreamSession{stop: make(chan struct{}), in: NewRateLimiter(0, 0), out: NewRateLimiter(0, 0)}
+	for _, limited := range []bool{false, true} {
+		a, b := net.Pipe()
+		fc := &fastConn{Conn: a}
+		sc, err := ss.newConn("", "", fc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limited {
+			sc.OutboundLimiter().SetLimit(1<<20, 0)
+			ss.InboundLimiter().SetLimit(1<<20, 0)
+		}
+		data := strings.Repeat("x", 10000)
+		go func() {
+			sc.ReadFrom(strings.NewReader(data))
+			sc.CloseWrite()
+			a.Close()
+		}()
+		var got bytes.Buffer
+		io.Copy(&got, b)
+		if got.String() != data {
+			t.Errorf("limited %v: ReadFrom sent %d bytes", limited, got.Len())
+		}
+		if fc.readFrom == limited {
+			t.Errorf("limited %v: fast path used %v", limited, fc.readFrom)
+		}
+		if n := sc.OutboundLimiter().Bytes(); n != uint64(len(data)) {
+			t.Errorf("limited %v: connection counted %d bytes", limited, n)
+		}
+
+		a, b = net.Pipe()
+		fc = &fastConn{Conn: a}
+		sc, _ = ss.newConn("", "", fc)
+		go func() {
+			b.Write([]byte(data))
+			b.Close()
+		}()
+		got.Reset()
+		sc.WriteTo(&got)
+		sc.Close()
+		if got.String() != data || fc.writeTo == limited {
+			t.Errorf("limited %v: WriteTo got %d bytes, fast path used %v", limited, got.Len(), fc.writeTo)
+		}
+		if n := sc.InboundLimiter().Bytes(); n != uint64(len(data)) {
+			t.Errorf("limited %v: connection counted %d bytes read", limited, n)
+		}
+	}
+	if n := ss.OutboundLimiter().Bytes(); n != 20000 {
+		t.Errorf("session counted %d bytes written, want 20000", n)
+	}
+}
+
+func Test_RateLimiter(t *testing.T) {
+	r := NewRateLimiter(100000, 0)
+	if rate, burst := r.Limit(); rate != 100000 || burst != 100000 {
+		t.Errorf("limit %d burst %d, want a second's worth of burst", rate, burst)
+	}
+	r.SetLimit(100, 0)
+	if _, burst := r.Limit(); burst != minBurst {
+		t.Errorf("burst %d, want at least %d", burst, minBurst)
+	}
+
+	r = NewRateLimiter(40000, 0)
+	start := time.Now()
+	r.take(40000)
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("a full bucket waited %v", d)
+	}
+	r.take(20000)
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("20000 bytes over the burst at 40000/s took %v, want about 500ms", d)
+	}
+	if r.Throttled() == 0 {
+		t.Error("no time throttled")
+	}
+	if n := r.Bytes(); n != 60000 {
+		t.Errorf("counted %d bytes, want 60000", n)
+	}
+
+	// lifting the limit ends the wait
+	r.take(40000)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.SetLimit(0, 0)
+	}()
+	start = time.Now()
+	r.take(1)
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("lifted limit waited %v", d)
+	}
+}
+
+func Test_SAMConnLimit(t *testing.T) {
+	ss := &StreamSession{stop: make(chan struct{}), in: NewRateLimiter(0, 0), out: NewRateLimiter(40000, 0)}
+	a, b := net.Pipe()
+	sc, err := ss.newConn("", "", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	go io.Copy(io.Discard, b)
+	start := time.Now()
+	if _, err := sc.Write(make([]byte, 60000)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("60000 bytes with a 40000 burst at 40000/s took %v, want about 500ms", d)
+	}
+	if n := sc.OutboundLimiter().Bytes(); n != 60000 {
+		t.Errorf("connection counted %d bytes, want 60000", n)
+	}
+	if ss.OutboundLimiter().Throttled() == 0 {
+		t.Error("session limit did not throttle the connection")
+	}
+}
@@ -0,0 +1,106 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// how long WaitReachable waits between probes, at first and at most
+const (
+	probeBackoff    = time.Second
+	maxProbeBackoff = 30 * time.Second
+)
+
+// WaitReachable waits until other destinations can connect to the session,
+// which is some time after it was created, once the router has published its
+// lease set. It creates a second session with a TRANSIENT destination on the
+// same bridge, and from it looks up the b32 address of the session and dials
+// it until both succeed, or ctx is done. The probe connections are closed
+// right away, so a listener of the session accepts them and reads EOF.
+//
+// The router delivers between its own destinations without their lease
+// sets, so a probe on the same bridge may get through before other routers
+// can. WaitReachableFrom probes from the bridge of another router instead.
+func (s *StreamSession) WaitReachable(ctx context.Context) error {
+	sam, err := s.newSAM(ctx)
+	if err != nil {
+		return err
+	}
+	return s.waitReachable(ctx, sam)
+}
+
+// WaitReachableFrom is WaitReachable with the probe session on the SAM bridge
+// at address, best one of another router. See NewSAM for the address forms.
+func (s *StreamSession) WaitReachableFrom(ctx context.Context, address string) error {
+	s.mu.Lock()
+	d := s.bridge.dialer
+	s.mu.Unlock()
+	t, err := parseSAMAddress(address, d)
+	if err != nil {
+		return err
+	}
+	sam, err := dialSAM(ctx, address, t)
+	if err != nil {
+		return err
+	}
+	return s.waitReachable(ctx, sam)
+}
+
+// probes the session from a new session on the bridge sam is connected to
+func (s *StreamSession) waitReachable(ctx context.Context, sam *SAM) error {
+	probe, err := sam.createStreamSession(ctx, "", "0", "0", i2pkeys.I2PKeys{}, Sig_NONE, nil)
+	if err != nil {
+		sam.Close()
+		return err
+	}
+	defer probe.Close()
+	wait := probeBackoff
+	for {
+		err := probe.probe(ctx, s.Addr())
+		if err == nil {
+			return nil
+		}
+		if !probeRetries(err) {
+			return err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-s.stop:
+			t.Stop()
+			return net.ErrClosed
+		case <-t.C:
+		}
+		if wait *= 2; wait > maxProbeBackoff {
+			wait = maxProbeBackoff
+		}
+	}
+}
+
+// looks up addr by its b32 address and connects to it once
+func (probe *StreamSession) probe(ctx context.Context, addr i2pkeys.I2PAddr) error {
+	found, err := probe.lookup(ctx, addr.Base32())
+	if err != nil {
+		return err
+	}
+	if found.Base32() != addr.Base32() {
+		return errors.New(addr.Base32() + " resolves to " + found.Base32())
+	}
+	conn, err := probe.dialOnce(ctx, found)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// reports whether a probe failed because the lease set is not out yet
+func probeRetries(err error) bool {
+	return errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrCantReachPeer) || errors.Is(err, ErrTimeout) || errors.Is(err, ErrI2PError)
+}
@@ -0,0 +1,86 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+func Test_WaitReachable(t *testing.T) {
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	sam, err := NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSession("service", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	b.mu.Lock()
+	b.unreachable = 1
+	b.names = map[string]i2pkeys.I2PAddr{ss.Addr().Base32(): ss.Addr()}
+	b.mu.Unlock()
+	if err := ss.WaitReachable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.count("STREAM CONNECT"); n != 2 {
+		t.Errorf("bridge got %d probes, want 2", n)
+	}
+	if n := b.count("NAMING LOOKUP"); n != 2 {
+		t.Errorf("bridge got %d lookups, want 2", n)
+	}
+	if n := b.count("SESSION CREATE"); n != 2 {
+		t.Errorf("bridge got %d sessions, want the service and the probe", n)
+	}
+
+	b.mu.Lock()
+	b.unreachable = 100
+	b.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := ss.WaitReachable(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting for an unreachable session: %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func Test_WaitReachableFrom(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	other := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	// the lease set is not out yet, then it is
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		other.mu.Lock()
+		other.names = map[string]i2pkeys.I2PAddr{ss.Addr().Base32(): ss.Addr()}
+		other.mu.Unlock()
+	}()
+	if err := ss.WaitReachableFrom(context.Background(), other.l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if n := other.count("NAMING LOOKUP"); n != 2 {
+		t.Errorf("other bridge got %d lookups, want 2", n)
+	}
+	if n := other.count("STREAM CONNECT"); n != 1 {
+		t.Errorf("other bridge got %d probes, want 1", n)
+	}
+	if n := b.count("STREAM CONNECT") + b.count("NAMING LOOKUP"); n != 0 {
+		t.Errorf("bridge of the session got %d probes", n)
+	}
+
+	// a name that resolves to someone else
+	wrong := newTestAddr(t)
+	other.mu.Lock()
+	other.names = map[string]i2pkeys.I2PAddr{ss.Addr().Base32(): wrong}
+	other.mu.Unlock()
+	if err := ss.WaitReachableFrom(context.Background(), other.l.Addr().String()); err == nil {
+		t.Error("no error when the session resolves to another destination")
+	}
+}
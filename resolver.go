@@ -49,14 +49,17 @@ func (sam *SAMResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
 	s.Split(bufio.ScanWords)
 
 	errStr := ""
+	result := ""
 	for s.Scan() {
 		text := s.Text()
 		//log.Println("SAM3", text)
 		if text == "RESULT=OK" {
 			continue
 		} else if text == "RESULT=INVALID_KEY" {
+			result = "INVALID_KEY"
 			errStr += "Invalid key - resolver."
 		} else if text == "RESULT=KEY_NOT_FOUND" {
+			result = "KEY_NOT_FOUND"
 			errStr += "Unable to resolve " + name
 		} else if text == "NAME="+name {
 			continue
@@ -68,5 +71,8 @@ func (sam *SAMResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
 			continue
 		}
 	}
+	if result != "" {
+		return i2pkeys.I2PAddr(""), &SAMError{result, errStr}
+	}
 	return i2pkeys.I2PAddr(""), errors.New(errStr)
 }
@@ -0,0 +1,145 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// DefaultRetryResults are the RESULT codes a RetryPolicy retries if its
+// Results are nil. Both are common while the lease set of a destination that
+// just came up is still spreading.
+var DefaultRetryResults = []string{"CANT_REACH_PEER", "TIMEOUT"}
+
+// RetryPolicy says when a StreamSession dials a destination again after a
+// failed attempt. The waits between attempts grow exponentially with some
+// jitter, and all of them count towards the dial's context, Timeout and
+// Deadline.
+type RetryPolicy struct {
+	// attempts in total, including the first. Less than 2 means no retries.
+	MaxAttempts int
+	// wait before the second attempt, doubled for every attempt after it.
+	// 1 second if zero.
+	Backoff time.Duration
+	// the longest wait between attempts, 1 minute if zero
+	MaxBackoff time.Duration
+	// how much the waits vary at random, from 0 to 1. With 0.2 a wait of
+	// 10s becomes one between 8s and 12s.
+	Jitter float64
+	// the RESULT codes that are retried, DefaultRetryResults if nil
+	Results []string
+	// OnAttempt, if set, is called after every attempt
+	OnAttempt func(DialEvent)
+}
+
+// DialEvent tells a RetryPolicy's OnAttempt how an attempt went.
+type DialEvent struct {
+	Addr i2pkeys.I2PAddr
+	// 1 for the first attempt
+	Attempt int
+	// why the attempt failed, nil if it succeeded
+	Err error
+	// how long until the next attempt, zero if there is none
+	Wait time.Duration
+}
+
+// SetRetryPolicy makes dials of the session follow p, nil to not retry.
+func (s *StreamSession) SetRetryPolicy(p *RetryPolicy) {
+	s.mu.Lock()
+	s.retry = p
+	s.mu.Unlock()
+}
+
+// RetryPolicy returns the policy set with SetRetryPolicy.
+func (s *StreamSession) RetryPolicy() *RetryPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retry
+}
+
+// retries reports whether a failed attempt with err is worth another.
+func (p *RetryPolicy) retries(err error) bool {
+	var serr *SAMError
+	if !errors.As(err, &serr) {
+		return false
+	}
+	results := p.Results
+	if results == nil {
+		results = DefaultRetryResults
+	}
+	for _, r := range results {
+		if r == serr.Result {
+			return true
+		}
+	}
+	return false
+}
+
+// wait returns how long to wait after the given attempt.
+func (p *RetryPolicy) wait(attempt int) time.Duration {
+	d, max := p.Backoff, p.MaxBackoff
+	if d <= 0 {
+		d = time.Second
+	}
+	if max <= 0 {
+		max = time.Minute
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		j := p.Jitter
+		if j > 1 {
+			j = 1
+		}
+		d = time.Duration(float64(d) * (1 + j*(2*rand.Float64()-1)))
+	}
+	return d
+}
+
+// dials addr following the retry policy of the session
+func (s *StreamSession) dialI2P(ctx context.Context, addr i2pkeys.I2PAddr) (*SAMConn, error) {
+	p := s.RetryPolicy()
+	if p == nil {
+		return s.dialOnce(ctx, addr)
+	}
+	for attempt := 1; ; attempt++ {
+		conn, err := s.dialOnce(ctx, addr)
+		ev := DialEvent{Addr: addr, Attempt: attempt, Err: err}
+		if err == nil || attempt >= p.MaxAttempts || !p.retries(err) || ctx.Err() != nil {
+			if p.OnAttempt != nil {
+				p.OnAttempt(ev)
+			}
+			return conn, err
+		}
+		wait := p.wait(attempt)
+		if d, ok := ctx.Deadline(); ok && time.Until(d) < wait {
+			// it would time out while waiting, better return the real reason
+			if p.OnAttempt != nil {
+				p.OnAttempt(ev)
+			}
+			return nil, err
+		}
+		ev.Wait = wait
+		if p.OnAttempt != nil {
+			p.OnAttempt(ev)
+		}
+		t := time.NewTimer(ev.Wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-s.stop:
+			t.Stop()
+			return nil, net.ErrClosed
+		case <-t.C:
+		}
+	}
+}
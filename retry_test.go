@@ -0,0 +1,123 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_RetryPolicyWait(t *testing.T) {
+	p := &RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := p.wait(attempt + 1); d != want {
+			t.Errorf("wait after attempt %d: %v, want %v", attempt+1, d, want)
+		}
+	}
+	if d := (&RetryPolicy{}).wait(1); d != time.Second {
+		t.Errorf("default first wait %v, want 1s", d)
+	}
+	p = &RetryPolicy{Backoff: 10 * time.Second, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if d := p.wait(1); d < 8*time.Second || d > 12*time.Second {
+			t.Fatalf("wait with jitter 0.2 of 10s: %v", d)
+		}
+	}
+}
+
+func Test_RetryPolicyResults(t *testing.T) {
+	p := &RetryPolicy{}
+	if !p.retries(&SAMError{Result: "CANT_REACH_PEER"}) || !p.retries(&SAMError{Result: "TIMEOUT"}) {
+		t.Error("default results are not retried")
+	}
+	if p.retries(&SAMError{Result: "INVALID_KEY"}) || p.retries(errors.New("EOF")) {
+		t.Error("other errors are retried")
+	}
+	p.Results = []string{"INVALID_KEY"}
+	if !p.retries(&SAMError{Result: "INVALID_KEY"}) || p.retries(&SAMError{Result: "TIMEOUT"}) {
+		t.Error("Results not followed")
+	}
+}
+
+func Test_DialRetry(t *testing.T) {
+	fb, ss := newLifecycleSession(t)
+	defer ss.Close()
+	var events []DialEvent
+	ss.SetRetryPolicy(&RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     10 * time.Millisecond,
+		OnAttempt:   func(ev DialEvent) { events = append(events, ev) },
+	})
+
+	fb.mu.Lock()
+	fb.unreachable = 2
+	fb.mu.Unlock()
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if len(events) != 3 {
+		t.Fatalf("%d attempts, want 3", len(events))
+	}
+	for i, ev := range events[:2] {
+		var serr *SAMError
+		if ev.Attempt != i+1 || !errors.As(ev.Err, &serr) || serr.Result != "CANT_REACH_PEER" || ev.Wait == 0 {
+			t.Errorf("attempt %d: %+v", i+1, ev)
+		}
+	}
+	if ev := events[2]; ev.Attempt != 3 || ev.Err != nil || ev.Wait != 0 {
+		t.Errorf("last attempt: %+v", ev)
+	}
+
+	events = nil
+	fb.mu.Lock()
+	fb.unreachable = 5
+	fb.mu.Unlock()
+	before := fb.count("STREAM CONNECT")
+	_, err = ss.DialI2P(ss.Addr())
+	var serr *SAMError
+	if !errors.As(err, &serr) || serr.Result != "CANT_REACH_PEER" {
+		t.Errorf("dial out of attempts: %v, want CANT_REACH_PEER", err)
+	}
+	if n := fb.count("STREAM CONNECT") - before; n != 3 {
+		t.Errorf("%d attempts, want MaxAttempts", n)
+	}
+}
+
+func Test_DialRetryDeadline(t *testing.T) {
+	fb, ss := newLifecycleSession(t)
+	defer ss.Close()
+	ss.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, Backoff: time.Minute})
+	fb.mu.Lock()
+	fb.unreachable = 1
+	fb.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := ss.DialContextI2P(ctx, "", ss.Addr().Base64())
+	var serr *SAMError
+	if !errors.As(err, &serr) || serr.Result != "CANT_REACH_PEER" {
+		t.Errorf("dial whose backoff passes the deadline: %v, want CANT_REACH_PEER", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("waited %v for a retry after the deadline", d)
+	}
+}
+
+func Test_DialRetryTimeout(t *testing.T) {
+	fb, ss := newLifecycleSession(t)
+	defer ss.Close()
+	ss.Timeout = 300 * time.Millisecond
+	ss.SetRetryPolicy(&RetryPolicy{MaxAttempts: 100, Backoff: 50 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	fb.mu.Lock()
+	fb.unreachable = 1000
+	fb.mu.Unlock()
+	start := time.Now()
+	if _, err := ss.DialI2P(ss.Addr()); err == nil {
+		t.Fatal("dial to an unreachable destination succeeded")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("DialI2P retried for %v past the session's Timeout", d)
+	}
+}
@@ -4,25 +4,26 @@ package sam3
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
 	"errors"
 	"io"
-	"math/rand"
 	"net"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/eyedeekay/i2pkeys"
-	"github.com/stealthrocket/net/wasip1"
 )
 
 // Used for controlling I2Ps SAMv3.
 type SAM struct {
-	address  string
-	conn     net.Conn
-	resolver *SAMResolver
-	Config   SAMEmit
-	keys     *i2pkeys.I2PKeys
-	sigType  int
+	address   string
+	transport *samTransport
+	conn      net.Conn
+	resolver  *SAMResolver
+	Config    SAMEmit
+	keys      *i2pkeys.I2PKeys
+	sigType   int
 }
 
 const (
@@ -42,31 +43,124 @@ const (
 	Sig_EdDSA_SHA512_Ed25519 = "SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"
 )
 
-var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+var letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
+// RandString returns 4 random letters, from crypto/rand.
 func RandString() string {
-	n := 4
-	b := make([]rune, n)
+	return randLetters(letters, 4)
+}
+
+// how many characters NewID adds to the prefix, 80 bits worth
+const idLen = 16
+
+// NewID returns a tunnel name that is unique with all but certainty, prefix
+// followed by random letters and digits from crypto/rand. Sessions created
+// with an empty name get one of these, with the IDPrefix of the SAM's config.
+func NewID(prefix string) string {
+	return prefix + randLetters("abcdefghijklmnopqrstuvwxyz234567", idLen)
+}
+
+// n characters picked at random from chars, which has at most 256
+func randLetters(chars string, n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// the system's random source is broken, nothing else will work
+		panic("sam3: crypto/rand failed: " + err.Error())
+	}
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		// modulo bias is fine for names
+		b[i] = chars[int(b[i])%len(chars)]
 	}
 	return string(b)
 }
 
-// Creates a new controller for the I2P routers SAM bridge.
+// a non-zero time far in the past, setting it as a deadline interrupts
+// blocking reads and writes right away
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watchContext makes blocking reads and writes on conn return when ctx is done
+// or its deadline passes. The returned function must be called once the
+// operation is over, it stops watching and returns the context's error if the
+// context interrupted the operation.
+func watchContext(ctx context.Context, conn net.Conn) func() error {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+	d, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		conn.SetDeadline(d)
+	}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(aLongTimeAgo)
+			done <- ctx.Err()
+		case <-stop:
+			done <- nil
+		}
+	}()
+	return func() error {
+		close(stop)
+		if err := <-done; err != nil {
+			return err
+		}
+		if hasDeadline {
+			conn.SetDeadline(time.Time{})
+			if !time.Now().Before(d) {
+				// the connection may time out before the context does
+				return context.DeadlineExceeded
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// Creates a new controller for the I2P routers SAM bridge. The address is
+// host:port, or tcp://host:port, tls://host:port or unix:///path/to/socket
+// to choose how to connect to it.
 func NewSAM(address string) (*SAM, error) {
+	return newSAM(context.Background(), address, nil)
+}
+
+// Creates a new controller for the I2P routers SAM bridge, connecting with d
+// instead of the DefaultDialer. Sessions made with it use d as well.
+func NewSAMWithDialer(address string, d Dialer) (*SAM, error) {
+	return newSAM(context.Background(), address, d)
+}
+
+func newSAM(ctx context.Context, address string, d Dialer) (*SAM, error) {
+	t, err := parseSAMAddress(address, d)
+	if err != nil {
+		return nil, err
+	}
+	return dialSAM(ctx, address, t)
+}
+
+// connects to the bridge at address through t and says hello
+func dialSAM(ctx context.Context, address string, t *samTransport) (*SAM, error) {
 	var s SAM
-	// TODO: clean this up
-	conn, err := wasip1.Dial("tcp", address)
+	s.address = address
+	s.transport = t
+	s.Config.I2PConfig.Dialer = t.dialer
+	conn, err := t.dial(ctx)
 	if err != nil {
 		return nil, err
 	}
+	stop := watchContext(ctx, conn)
 	if _, err := conn.Write(s.Config.HelloBytes()); err != nil {
+		if cerr := stop(); cerr != nil {
+			err = cerr
+		}
 		conn.Close()
 		return nil, err
 	}
 	buf := make([]byte, 256)
 	n, err := conn.Read(buf)
+	if cerr := stop(); cerr != nil {
+		err = cerr
+	}
 	if err != nil {
 		conn.Close()
 		return nil, err
@@ -108,39 +202,11 @@ func (sam *SAM) ReadKeys(r io.Reader) (err error) {
 
 // if keyfile fname does not exist
 func (sam *SAM) EnsureKeyfile(fname string) (keys i2pkeys.I2PKeys, err error) {
-	if fname == "" {
-		// transient
-		keys, err = sam.NewKeys()
-		if err == nil {
-			sam.Config.I2PConfig.DestinationKeys = keys
-		}
-	} else {
-		// persistent
-		_, err = os.Stat(fname)
-		if os.IsNotExist(err) {
-			// make the keys
-			keys, err = sam.NewKeys()
-			if err == nil {
-				sam.Config.I2PConfig.DestinationKeys = keys
-				// save keys
-				var f io.WriteCloser
-				f, err = os.OpenFile(fname, os.O_WRONLY|os.O_CREATE, 0600)
-				if err == nil {
-					err = i2pkeys.StoreKeysIncompat(keys, f)
-					f.Close()
-				}
-			}
-		} else if err == nil {
-			// we haz key file
-			var f *os.File
-			f, err = os.Open(fname)
-			if err == nil {
-				keys, err = i2pkeys.LoadKeysIncompat(f)
-				if err == nil {
-					sam.Config.I2PConfig.DestinationKeys = keys
-				}
-			}
-		}
+	keys, err = ensureKeyfile(fname, func() (i2pkeys.I2PKeys, error) {
+		return sam.NewKeys()
+	})
+	if err == nil {
+		sam.Config.I2PConfig.DestinationKeys = keys
 	}
 	return
 }
@@ -207,9 +273,22 @@ func (sam *SAM) newGenericSessionWithSignature(style, id string, keys i2pkeys.I2
 // setting extra to something else than []string{}.
 // This sam3 instance is now a session
 func (sam *SAM) newGenericSessionWithSignatureAndPorts(style, id, from, to string, keys i2pkeys.I2PKeys, sigType string, options []string, extras []string) (net.Conn, error) {
+	conn, _, err := sam.createSession(context.Background(), style, id, from, to, keys, sigType, options, extras)
+	return conn, err
+}
+
+// createSession creates the session and returns its keys. If keys is empty
+// the router creates a TRANSIENT destination of sigType, and the keys are the
+// ones it made.
+func (sam *SAM) createSession(ctx context.Context, style, id, from, to string, keys i2pkeys.I2PKeys, sigType string, options []string, extras []string) (net.Conn, i2pkeys.I2PKeys, error) {
 
 	optStr := GenerateOptionString(options)
 
+	if expires, ok := OfflineExpires(keys); ok && !expires.After(time.Now()) {
+		sam.conn.Close()
+		return nil, keys, errors.New("Offline signature expired at " + expires.String())
+	}
+
 	conn := sam.conn
 	fp := ""
 	tp := ""
@@ -219,48 +298,80 @@ func (sam *SAM) newGenericSessionWithSignatureAndPorts(style, id, from, to strin
 	if to != "0" {
 		tp = " TO_PORT=" + to
 	}
-	scmsg := []byte("SESSION CREATE STYLE=" + style + fp + tp + " ID=" + id + " DESTINATION=" + keys.String() + " " + optStr + strings.Join(extras, " ") + "\n")
-	for m, i := 0, 0; m != len(scmsg); i++ {
-		if i == 15 {
-			conn.Close()
-			return nil, errors.New("writing to SAM failed")
-		}
-		n, err := conn.Write(scmsg[m:])
+	dest := " DESTINATION=" + keys.String()
+	transient := keys.String() == ""
+	if transient {
+		spec, err := parseSigType(sigType)
 		if err != nil {
-			conn.Close()
-			return nil, err
+			sam.conn.Close()
+			return nil, keys, err
 		}
-		m += n
+		dest = " DESTINATION=TRANSIENT SIGNATURE_TYPE=" + spec.name
+	}
+	scmsg := []byte("SESSION CREATE STYLE=" + style + fp + tp + " ID=" + id + dest + " " + optStr + strings.Join(extras, " ") + "\n")
+	// the reply comes once the tunnels are built, which can take minutes
+	stop := watchContext(ctx, conn)
+	text, err := sessionCreate(conn, scmsg)
+	if cerr := stop(); cerr != nil {
+		// the router drops the half-built session with the socket
+		conn.Close()
+		return nil, keys, cerr
 	}
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
 	if err != nil {
 		conn.Close()
-		return nil, err
+		return nil, keys, err
 	}
-	text := string(buf[:n])
 	if strings.HasPrefix(text, session_OK) {
-		if keys.String() != text[len(session_OK):len(text)-1] {
+		priv := strings.TrimSpace(text[len(session_OK):])
+		if transient {
+			p, err := parsePrivateKeys(i2pkeys.NewKeys("", priv))
+			if err != nil {
+				conn.Close()
+				return nil, keys, errors.New("SAMv3 sent invalid keys for a transient destination: " + err.Error())
+			}
+			return conn, i2pkeys.NewKeys(p.Keys().Addr(), priv), nil
+		}
+		if keys.String() != priv {
 			conn.Close()
-			return nil, errors.New("SAMv3 created a tunnel with keys other than the ones we asked it for")
+			return nil, keys, errors.New("SAMv3 created a tunnel with keys other than the ones we asked it for")
 		}
-		return conn, nil //&StreamSession{id, conn, keys, nil, sync.RWMutex{}, nil}, nil
+		return conn, keys, nil //&StreamSession{id, conn, keys, nil, sync.RWMutex{}, nil}, nil
 	} else if text == session_DUPLICATE_ID {
 		conn.Close()
-		return nil, errors.New("Duplicate tunnel name")
+		return nil, keys, ErrDuplicatedID
 	} else if text == session_DUPLICATE_DEST {
 		conn.Close()
-		return nil, errors.New("Duplicate destination")
+		return nil, keys, ErrDuplicatedDest
 	} else if text == session_INVALID_KEY {
 		conn.Close()
-		return nil, errors.New("Invalid key - SAM session")
+		return nil, keys, errors.New("Invalid key - SAM session")
 	} else if strings.HasPrefix(text, session_I2P_ERROR) {
 		conn.Close()
-		return nil, errors.New("I2P error " + text[len(session_I2P_ERROR):])
+		return nil, keys, errors.New("I2P error " + text[len(session_I2P_ERROR):])
 	} else {
 		conn.Close()
-		return nil, errors.New("Unable to parse SAMv3 reply: " + text)
+		return nil, keys, errors.New("Unable to parse SAMv3 reply: " + text)
+	}
+}
+
+// writes a SESSION CREATE and reads the reply
+func sessionCreate(conn net.Conn, scmsg []byte) (string, error) {
+	for m, i := 0, 0; m != len(scmsg); i++ {
+		if i == 15 {
+			return "", errors.New("writing to SAM failed")
+		}
+		n, err := conn.Write(scmsg[m:])
+		if err != nil {
+			return "", err
+		}
+		m += n
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
 	}
+	return string(buf[:n]), nil
 }
 
 // close this sam session
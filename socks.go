@@ -0,0 +1,272 @@
+package sam3
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socks5Version = 5
+
+	socksAuthNone         = 0
+	socksAuthPassword     = 2
+	socksAuthNoAcceptable = 0xff
+
+	socksCmdConnect = 1
+
+	socksAtypIPv4   = 1
+	socksAtypDomain = 3
+	socksAtypIPv6   = 4
+
+	socksSucceeded        = 0
+	socksNotAllowed       = 2
+	socksHostUnreachable  = 4
+	socksCmdNotSupported  = 7
+	socksAtypNotSupported = 8
+	socksPasswordVersion  = 1
+	socksPasswordOK       = 0
+	socksPasswordRejected = 1
+)
+
+// SOCKSServer is a SOCKS5 proxy which connects to .i2p and .b32.i2p hosts
+// through a StreamSession. Only CONNECT is supported.
+type SOCKSServer struct {
+	Session *StreamSession
+	// if Username is set, clients have to log in with it and Password
+	Username string
+	Password string
+	// Outproxy is the .i2p host of a SOCKS5 outproxy, connections to other
+	// hosts are made through it. Without an outproxy they are refused.
+	Outproxy string
+	// connections are closed after being idle for this long, if not zero
+	IdleTimeout time.Duration
+}
+
+// NewSOCKSServer returns a SOCKSServer using session.
+func NewSOCKSServer(session *StreamSession) *SOCKSServer {
+	return &SOCKSServer{Session: session}
+}
+
+// Serve accepts SOCKS clients on l until it fails.
+func (s *SOCKSServer) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(c); err != nil {
+				log.Printf("socks: %s: %s", c.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn speaks SOCKS5 with one client on c and then relays the connection.
+// c is closed when done.
+func (s *SOCKSServer) ServeConn(c net.Conn) error {
+	defer c.Close()
+	if err := s.negotiate(c); err != nil {
+		return err
+	}
+	host, port, err := readSOCKSRequest(c)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		// already answered
+		return errors.New("unsupported SOCKS request")
+	}
+	var remote net.Conn
+	if IsI2PHost(host) {
+		remote, err = s.Session.DialContext(context.Background(), "tcp", net.JoinHostPort(host, port))
+	} else if s.Outproxy != "" {
+		remote, err = s.dialOutproxy(host, port)
+	} else {
+		writeSOCKSReply(c, socksNotAllowed)
+		return errors.New("refusing " + host + ", not an I2P site and no outproxy")
+	}
+	if err != nil {
+		writeSOCKSReply(c, socksHostUnreachable)
+		return err
+	}
+	defer remote.Close()
+	if err := writeSOCKSReply(c, socksSucceeded); err != nil {
+		return err
+	}
+	_, _, err = Pipe(c, remote, s.IdleTimeout)
+	return err
+}
+
+// picks the authentication method and checks the password
+func (s *SOCKSServer) negotiate(c net.Conn) error {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(c, hdr); err != nil {
+		return err
+	}
+	if hdr[0] != socks5Version {
+		return errors.New("not SOCKS5")
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return err
+	}
+	want := byte(socksAuthNone)
+	if s.Username != "" {
+		want = socksAuthPassword
+	}
+	offered := false
+	for _, m := range methods {
+		if m == want {
+			offered = true
+		}
+	}
+	if !offered {
+		c.Write([]byte{socks5Version, socksAuthNoAcceptable})
+		return errors.New("no acceptable SOCKS authentication method")
+	}
+	if _, err := c.Write([]byte{socks5Version, want}); err != nil {
+		return err
+	}
+	if want == socksAuthNone {
+		return nil
+	}
+	// RFC 1929
+	if _, err := io.ReadFull(c, hdr); err != nil {
+		return err
+	}
+	if hdr[0] != socksPasswordVersion {
+		return errors.New("bad SOCKS password authentication version")
+	}
+	user := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c, user); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c, hdr[:1]); err != nil {
+		return err
+	}
+	pass := make([]byte, hdr[0])
+	if _, err := io.ReadFull(c, pass); err != nil {
+		return err
+	}
+	userOK := subtle.ConstantTimeCompare(user, []byte(s.Username))
+	passOK := subtle.ConstantTimeCompare(pass, []byte(s.Password))
+	if userOK&passOK != 1 {
+		c.Write([]byte{socksPasswordVersion, socksPasswordRejected})
+		return errors.New("SOCKS authentication failed for " + string(user))
+	}
+	_, err := c.Write([]byte{socksPasswordVersion, socksPasswordOK})
+	return err
+}
+
+// reads a request and returns where to connect to. It answers requests it
+// does not support itself and returns an empty host for them.
+func readSOCKSRequest(c net.Conn) (host, port string, err error) {
+	hdr := make([]byte, 4)
+	if _, err = io.ReadFull(c, hdr); err != nil {
+		return
+	}
+	if hdr[0] != socks5Version {
+		err = errors.New("not SOCKS5")
+		return
+	}
+	switch hdr[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make(net.IP, 4)
+		if hdr[3] == socksAtypIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err = io.ReadFull(c, ip); err != nil {
+			return
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		if _, err = io.ReadFull(c, hdr[:1]); err != nil {
+			return
+		}
+		name := make([]byte, hdr[0])
+		if _, err = io.ReadFull(c, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		err = writeSOCKSReply(c, socksAtypNotSupported)
+		return
+	}
+	p := make([]byte, 2)
+	if _, err = io.ReadFull(c, p); err != nil {
+		return
+	}
+	port = strconv.Itoa(int(binary.BigEndian.Uint16(p)))
+	if hdr[1] != socksCmdConnect {
+		host = ""
+		err = writeSOCKSReply(c, socksCmdNotSupported)
+	}
+	return
+}
+
+func writeSOCKSReply(c net.Conn, code byte) error {
+	// the bound address means nothing over I2P
+	_, err := c.Write([]byte{socks5Version, code, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// connects to host:port through the SOCKS5 outproxy
+func (s *SOCKSServer) dialOutproxy(host, port string) (net.Conn, error) {
+	conn, err := s.Session.DialContext(context.Background(), "tcp", s.Outproxy)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || len(host) > 255 {
+		conn.Close()
+		return nil, errors.New("invalid address " + net.JoinHostPort(host, port))
+	}
+	req := []byte{socks5Version, 1, socksAuthNone}
+	req = append(req, socks5Version, socksCmdConnect, 0, socksAtypDomain, byte(len(host)))
+	req = append(req, host...)
+	req = append(req, byte(p>>8), byte(p))
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// method choice, then the reply header up to the address type
+	resp := make([]byte, 6)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp[1] != socksAuthNone || resp[3] != socksSucceeded {
+		conn.Close()
+		return nil, errors.New("outproxy refused " + net.JoinHostPort(host, port))
+	}
+	var skip int
+	switch resp[5] {
+	case socksAtypIPv4:
+		skip = 4 + 2
+	case socksAtypIPv6:
+		skip = 16 + 2
+	case socksAtypDomain:
+		if _, err := io.ReadFull(conn, resp[:1]); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		skip = int(resp[0]) + 2
+	default:
+		conn.Close()
+		return nil, errors.New("bad reply from outproxy")
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
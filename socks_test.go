@@ -0,0 +1,170 @@
+package sam3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+// socksConnect logs in to s over a pipe, if user is set, and asks it to
+// CONNECT to host:port with the given command. It returns the client side
+// and the reply code, or the authentication status if that failed.
+func socksConnect(t *testing.T, s *SOCKSServer, user, pass string, cmd byte, host string, port uint16) (net.Conn, byte) {
+	c, srv := net.Pipe()
+	go s.ServeConn(srv)
+	method := byte(socksAuthNone)
+	if user != "" {
+		method = socksAuthPassword
+	}
+	c.Write([]byte{socks5Version, 1, method})
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp[1] != method {
+		return c, resp[1]
+	}
+	if user != "" {
+		auth := append([]byte{socksPasswordVersion, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(pass))), pass...)
+		c.Write(auth)
+		if _, err := io.ReadFull(c, resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp[1] != socksPasswordOK {
+			return c, resp[1]
+		}
+	}
+	req := []byte{socks5Version, cmd, 0}
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		req = append(append(req, socksAtypIPv4), ip...)
+	} else {
+		req = append(append(req, socksAtypDomain, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	c.Write(req)
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(c, reply); err != nil {
+		t.Fatal(err)
+	}
+	return c, reply[1]
+}
+
+// echoes a line back through c
+func socksEcho(t *testing.T, c net.Conn) {
+	c.Write([]byte("ping\n"))
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(c, got); err != nil || string(got) != "ping\n" {
+		t.Errorf("relayed %q, %v", got, err)
+	}
+}
+
+func Test_SOCKSServer(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	b.mu.Lock()
+	b.names = map[string]i2pkeys.I2PAddr{"site.i2p": newTestAddr(t)}
+	b.peer = func(c net.Conn) { io.Copy(c, c) }
+	b.mu.Unlock()
+	s := NewSOCKSServer(ss)
+	s.Username, s.Password = "user", "secret"
+
+	c, code := socksConnect(t, s, "user", "secret", socksCmdConnect, "site.i2p", 80)
+	if code != socksSucceeded {
+		t.Fatalf("CONNECT to site.i2p: reply %d", code)
+	}
+	socksEcho(t, c)
+	c.Close()
+
+	c, code = socksConnect(t, s, "user", "wrong", socksCmdConnect, "site.i2p", 80)
+	if code != socksPasswordRejected {
+		t.Errorf("wrong password: status %d", code)
+	}
+	c.Close()
+	c, code = socksConnect(t, s, "", "", socksCmdConnect, "site.i2p", 80)
+	if code != socksAuthNoAcceptable {
+		t.Errorf("no password: method %d", code)
+	}
+	c.Close()
+
+	s.Username = ""
+	for _, host := range []string{"example.com", "10.0.0.1"} {
+		c, code = socksConnect(t, s, "", "", socksCmdConnect, host, 80)
+		if code != socksNotAllowed {
+			t.Errorf("CONNECT to %s without an outproxy: reply %d", host, code)
+		}
+		c.Close()
+	}
+	c, code = socksConnect(t, s, "", "", 2, "site.i2p", 80)
+	if code != socksCmdNotSupported {
+		t.Errorf("BIND: reply %d", code)
+	}
+	c.Close()
+	if n := b.count("STREAM CONNECT"); n != 1 {
+		t.Errorf("bridge got %d STREAM CONNECTs, want 1", n)
+	}
+}
+
+func Test_SOCKSOutproxy(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	requests := make(chan []byte, 2)
+	refuse := false
+	b.mu.Lock()
+	b.names = map[string]i2pkeys.I2PAddr{"exit.i2p": newTestAddr(t)}
+	// a SOCKS5 outproxy answering with a domain name as the bound address
+	b.peer = func(c net.Conn) {
+		greeting := make([]byte, 3)
+		if _, err := io.ReadFull(c, greeting); err != nil {
+			return
+		}
+		c.Write([]byte{socks5Version, socksAuthNone})
+		req := make([]byte, 5)
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+		rest := make([]byte, int(req[4])+2)
+		if _, err := io.ReadFull(c, rest); err != nil {
+			return
+		}
+		requests <- append(req, rest...)
+		b.mu.Lock()
+		refused := refuse
+		b.mu.Unlock()
+		if refused {
+			c.Write([]byte{socks5Version, 5, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		c.Write([]byte{socks5Version, socksSucceeded, 0, socksAtypDomain, 4, 'e', 'x', 'i', 't', 0, 1})
+		io.Copy(c, c)
+	}
+	b.mu.Unlock()
+	s := NewSOCKSServer(ss)
+	s.Outproxy = "exit.i2p:1080"
+
+	c, code := socksConnect(t, s, "", "", socksCmdConnect, "example.com", 443)
+	if code != socksSucceeded {
+		t.Fatalf("CONNECT through the outproxy: reply %d", code)
+	}
+	want := []byte{socks5Version, socksCmdConnect, 0, socksAtypDomain, 11}
+	want = binary.BigEndian.AppendUint16(append(want, "example.com"...), 443)
+	if req := <-requests; !bytes.Equal(req, want) {
+		t.Errorf("outproxy got request %v, want %v", req, want)
+	}
+	socksEcho(t, c)
+	c.Close()
+
+	b.mu.Lock()
+	refuse = true
+	b.mu.Unlock()
+	c, code = socksConnect(t, s, "", "", socksCmdConnect, "example.com", 443)
+	if code != socksHostUnreachable {
+		t.Errorf("CONNECT the outproxy refused: reply %d", code)
+	}
+	<-requests
+	c.Close()
+}
@@ -7,7 +7,7 @@ import (
 	"errors"
 	"io"
 	"net"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/eyedeekay/i2pkeys"
@@ -15,7 +15,8 @@ import (
 
 // Represents a streaming session.
 type StreamSession struct {
-	samAddr  string          // address to the sam bridge (ipv4:port)
+	samAddr  string          // address to the sam bridge, as given to NewSAM
+	bridge   *samTransport   // connects to the sam bridge
 	id       string          // tunnel name
 	conn     net.Conn        // connection to sam
 	keys     i2pkeys.I2PKeys // i2p destination keys
@@ -24,6 +25,101 @@ type StreamSession struct {
 	sigType  string
 	from     string
 	to       string
+	options  []string      // i2cp and streaming options the session was created with
+	mu       sync.Mutex    // guards conn, keys and the bridge when the session is re-created
+	renew    sync.Mutex    // held while the session is re-created, which can take minutes
+	stop     chan struct{} // closed when the session is closed
+	once     sync.Once
+	// limits and counts the bytes of all connections, and the limits new
+	// connections start with
+	in, out         *RateLimiter
+	connIn, connOut int
+	retry           *RetryPolicy
+	// connection for lookups, guarded by mu, lookupMu serializes its use
+	resolver *SAM
+	lookupMu sync.Mutex
+
+	// how long DialAny waits before trying the next destination,
+	// DefaultFallbackDelay if zero
+	FallbackDelay time.Duration
+	// connections of the session, and the ones to the SAM bridge of dials
+	// and accepts in progress, guarded by mu. Close closes them all.
+	children map[io.Closer]struct{}
+	draining bool
+	idle     chan struct{} // closed once draining and no connections are left
+	done     chan struct{} // closed once Close is over
+}
+
+func (sam *SAM) newStreamSession(id string, conn net.Conn, keys i2pkeys.I2PKeys, options []string, sigType, from, to string) *StreamSession {
+	return &StreamSession{
+		samAddr: sam.address,
+		bridge:  sam.sessionTransport(),
+		id:      id,
+		conn:    conn,
+		keys:    keys,
+		Timeout: time.Duration(600 * time.Second),
+		sigType: sigType,
+		from:    from,
+		to:      to,
+		options: options,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		in:      NewRateLimiter(sam.Config.I2PConfig.bandwidth(sam.Config.I2PConfig.BandwidthIn), 0),
+		out:     NewRateLimiter(sam.Config.I2PConfig.bandwidth(sam.Config.I2PConfig.BandwidthOut), 0),
+		connIn:  sam.Config.I2PConfig.bandwidth(sam.Config.I2PConfig.BandwidthInConn),
+		connOut: sam.Config.I2PConfig.bandwidth(sam.Config.I2PConfig.BandwidthOutConn),
+	}
+}
+
+// opens another connection to the SAM bridge of the session
+func (s *StreamSession) newSAM(ctx context.Context) (*SAM, error) {
+	s.mu.Lock()
+	addr, bridge := s.samAddr, s.bridge
+	s.mu.Unlock()
+	return dialSAM(ctx, addr, bridge)
+}
+
+// wraps a stream of the session, which closes it with the session
+func (s *StreamSession) newConn(laddr, raddr i2pkeys.I2PAddr, conn net.Conn) (*SAMConn, error) {
+	s.mu.Lock()
+	in, out := s.connIn, s.connOut
+	s.mu.Unlock()
+	sc := &SAMConn{
+		laddr:      laddr,
+		raddr:      raddr,
+		conn:       conn,
+		in:         NewRateLimiter(in, 0),
+		out:        NewRateLimiter(out, 0),
+		sessionIn:  s.in,
+		sessionOut: s.out,
+		session:    s,
+	}
+	if err := s.track(sc); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+// InboundLimiter limits and counts the bytes read from all connections of the
+// session together.
+func (s *StreamSession) InboundLimiter() *RateLimiter {
+	return s.in
+}
+
+// OutboundLimiter limits and counts the bytes written to all connections of
+// the session together.
+func (s *StreamSession) OutboundLimiter() *RateLimiter {
+	return s.out
+}
+
+// SetConnLimits sets the bytes per second each new connection may read and
+// write, 0 for no limit. Connections already open keep theirs, use their
+// InboundLimiter and OutboundLimiter to change them.
+func (s *StreamSession) SetConnLimits(in, out int) {
+	s.mu.Lock()
+	s.connIn, s.connOut = in, out
+	s.mu.Unlock()
 }
 
 func (s *StreamSession) SetDeadline(t time.Time) error {
@@ -55,89 +151,188 @@ func (ss *StreamSession) ID() string {
 	return ss.id
 }
 
+// Close closes the session with its connections and listeners, and
+// interrupts its dials and accepts, which return net.ErrClosed then, as do new
+// ones. Closing it again does nothing.
 func (ss *StreamSession) Close() error {
-	return ss.conn.Close()
+	var err error
+	ss.once.Do(func() {
+		close(ss.stop)
+		ss.mu.Lock()
+		if ss.resolver != nil {
+			// interrupts a lookup in progress too
+			ss.resolver.Close()
+			ss.resolver = nil
+		}
+		err = ss.conn.Close()
+		children := ss.children
+		ss.children = nil
+		ss.mu.Unlock()
+		for c := range children {
+			c.Close()
+		}
+		close(ss.done)
+	})
+	return err
 }
 
 // Returns the I2P destination (the address) of the stream session
 func (ss *StreamSession) Addr() i2pkeys.I2PAddr {
-	return ss.keys.Addr()
+	return ss.Keys().Addr()
 }
 
 func (ss *StreamSession) LocalAddr() net.Addr {
-	return ss.keys.Addr()
+	return ss.Keys().Addr()
 }
 
-// Returns the keys associated with the stream session
+// Returns the keys associated with the stream session. For a session with a
+// TRANSIENT destination these are the keys the router generated.
 func (ss *StreamSession) Keys() i2pkeys.I2PKeys {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
 	return ss.keys
 }
 
 // Creates a new StreamSession with the I2CP- and streaminglib options as
-// specified. See the I2P documentation for a full list of options.
+// specified. See the I2P documentation for a full list of options. With empty
+// keys, i2pkeys.I2PKeys{}, the session gets a new TRANSIENT destination,
+// without a DEST GENERATE first. Keys returns it. With an empty id the session
+// gets a unique name, which ID returns.
 func (sam *SAM) NewStreamSession(id string, keys i2pkeys.I2PKeys, options []string) (*StreamSession, error) {
-	conn, err := sam.newGenericSession("STREAM", id, keys, options, []string{})
-	if err != nil {
-		return nil, err
-	}
-	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), Sig_NONE, "0", "0"}, nil
+	return sam.createStreamSession(context.Background(), id, "0", "0", keys, Sig_NONE, options)
 }
 
 // Creates a new StreamSession with the I2CP- and streaminglib options as
-// specified. See the I2P documentation for a full list of options.
+// specified. See the I2P documentation for a full list of options. With empty
+// keys the TRANSIENT destination has the signature type sigType.
 func (sam *SAM) NewStreamSessionWithSignature(id string, keys i2pkeys.I2PKeys, options []string, sigType string) (*StreamSession, error) {
-	conn, err := sam.newGenericSessionWithSignature("STREAM", id, keys, sigType, options, []string{})
-	if err != nil {
-		return nil, err
-	}
-	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), sigType, "0", "0"}, nil
+	return sam.createStreamSession(context.Background(), id, "0", "0", keys, sigType, options)
 }
 
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *SAM) NewStreamSessionWithSignatureAndPorts(id, from, to string, keys i2pkeys.I2PKeys, options []string, sigType string) (*StreamSession, error) {
-	conn, err := sam.newGenericSessionWithSignatureAndPorts("STREAM", id, from, to, keys, sigType, options, []string{})
-	if err != nil {
-		return nil, err
+	return sam.createStreamSession(context.Background(), id, from, to, keys, sigType, options)
+}
+
+// how often a session with a generated name is tried under a fresh one
+const maxIDAttempts = 5
+
+// creates a stream session. With an empty id the session gets a name from
+// NewID, and if the router already has a session of that name another is
+// tried. A name given by the caller is used as it is.
+func (sam *SAM) createStreamSession(ctx context.Context, id, from, to string, keys i2pkeys.I2PKeys, sigType string, options []string) (*StreamSession, error) {
+	generated := id == ""
+	for attempt := 1; ; attempt++ {
+		if generated {
+			id = NewID(sam.Config.I2PConfig.IDPrefix)
+		}
+		conn, k, err := sam.createSession(ctx, "STREAM", id, from, to, keys, sigType, options, []string{})
+		if err == nil {
+			return sam.newStreamSession(id, conn, k, options, sigType, from, to), nil
+		}
+		if !generated || !errors.Is(err, ErrDuplicatedID) || attempt == maxIDAttempts {
+			return nil, err
+		}
+		// the router hung up after refusing the name
+		fresh, err := dialSAM(ctx, sam.address, sam.sessionTransport())
+		if err != nil {
+			return nil, err
+		}
+		sam.conn = fresh.conn
 	}
-	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), sigType, from, to}, nil
 }
 
-// lookup name, convenience function
+// lookup name, convenience function. The lookups of a session share one
+// connection to the SAM bridge, opened by the first one.
 func (s *StreamSession) Lookup(name string) (i2pkeys.I2PAddr, error) {
-	sam, err := NewSAM(s.samAddr)
-	if err == nil {
+	return s.lookup(context.Background(), name)
+}
+
+func (s *StreamSession) lookup(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+	s.lookupMu.Lock()
+	defer s.lookupMu.Unlock()
+	for retried := false; ; retried = true {
+		sam, err := s.lookupSAM(ctx)
+		if err != nil {
+			return i2pkeys.I2PAddr(""), err
+		}
+		stop := watchContext(ctx, sam.conn)
 		addr, err := sam.Lookup(name)
-		defer sam.Close()
-		return addr, err
+		if cerr := stop(); cerr != nil {
+			// the reply may still come, the connection is out of step
+			s.dropLookupSAM()
+			return i2pkeys.I2PAddr(""), cerr
+		}
+		var serr *SAMError
+		if err == nil || errors.As(err, &serr) {
+			return addr, err
+		}
+		// the connection broke, possibly a while ago, so try once more on a
+		// new one
+		s.dropLookupSAM()
+		if retried {
+			return addr, err
+		}
 	}
-	return i2pkeys.I2PAddr(""), err
 }
 
-// context-aware dialer, eventually...
+// returns the connection for lookups, connecting if there is none. Called
+// with lookupMu held.
+func (s *StreamSession) lookupSAM(ctx context.Context) (*SAM, error) {
+	s.mu.Lock()
+	sam := s.resolver
+	s.mu.Unlock()
+	if sam != nil {
+		return sam, nil
+	}
+	sam, err := s.newSAM(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stop:
+		sam.Close()
+		return nil, net.ErrClosed
+	default:
+	}
+	s.resolver = sam
+	return sam, nil
+}
+
+// closes the connection for lookups. Called with lookupMu held.
+func (s *StreamSession) dropLookupSAM() {
+	s.mu.Lock()
+	sam := s.resolver
+	s.resolver = nil
+	s.mu.Unlock()
+	if sam != nil {
+		sam.Close()
+	}
+}
+
+// context-aware dialer, implements the DialContext of net.Dialer
 func (s *StreamSession) DialContext(ctx context.Context, n, addr string) (net.Conn, error) {
 	return s.DialContextI2P(ctx, n, addr)
 }
 
-// context-aware dialer, eventually...
+// context-aware dialer, addr is a .i2p or .b32.i2p name or a base64
+// destination. The dial is abandoned when ctx is done, or Timeout or Deadline
+// pass.
 func (s *StreamSession) DialContextI2P(ctx context.Context, n, addr string) (*SAMConn, error) {
 	if ctx == nil {
 		panic("nil context")
 	}
-	deadline := s.deadline(ctx, time.Now())
-	if !deadline.IsZero() {
-		if d, ok := ctx.Deadline(); !ok || deadline.Before(d) {
-			subCtx, cancel := context.WithDeadline(ctx, deadline)
-			defer cancel()
-			ctx = subCtx
-		}
-	}
+	ctx, cancel := s.dialContext(ctx)
+	defer cancel()
 
-	i2paddr, err := i2pkeys.NewI2PAddrFromString(addr)
+	i2paddr, err := s.resolve(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
-	return s.DialI2P(i2paddr)
+	return s.dialI2P(ctx, i2paddr)
 }
 
 /*
@@ -157,6 +352,17 @@ func minNonzeroTime(a, b time.Time) time.Time {
 	return b
 }
 
+// dialContext returns ctx ending by the deadline of the session as well
+func (s *StreamSession) dialContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline := s.deadline(ctx, time.Now())
+	if !deadline.IsZero() {
+		if d, ok := ctx.Deadline(); !ok || deadline.Before(d) {
+			return context.WithDeadline(ctx, deadline)
+		}
+	}
+	return ctx, func() {}
+}
+
 // deadline returns the earliest of:
 //   - now+Timeout
 //   - d.Deadline
@@ -175,47 +381,64 @@ func (s *StreamSession) deadline(ctx context.Context, now time.Time) (earliest t
 
 // implement net.Dialer
 func (s *StreamSession) Dial(n, addr string) (c net.Conn, err error) {
+	return s.DialContext(context.Background(), n, addr)
+}
 
-	var i2paddr i2pkeys.I2PAddr
-	var host string
-	host, _, err = SplitHostPort(addr)
-	//log.Println("Dialing:", host)
-	if err = IgnorePortError(err); err == nil {
-		// check for name
-		if strings.HasSuffix(host, ".b32.i2p") || strings.HasSuffix(host, ".i2p") {
-			// name lookup
-			i2paddr, err = s.Lookup(host)
-			//log.Println("Lookup:", i2paddr, err)
-		} else {
-			// probably a destination
-			i2paddr, err = i2pkeys.NewI2PAddrFromBytes([]byte(host))
-			//i2paddr = i2pkeys.I2PAddr(host)
-			//log.Println("Destination:", i2paddr, err)
-		}
-		if err == nil {
-			return s.DialI2P(i2paddr)
-		}
+// resolves the host part of addr, which is a .i2p or .b32.i2p name or a
+// base64 destination, the port is ignored
+func (s *StreamSession) resolve(ctx context.Context, addr string) (i2pkeys.I2PAddr, error) {
+	host, _, err := SplitHostPort(addr)
+	if err = IgnorePortError(err); err != nil {
+		return i2pkeys.I2PAddr(""), err
+	}
+	// check for name
+	if name, ok := i2pName(host); ok {
+		// name lookup
+		return s.lookup(ctx, name)
 	}
-	return
+	// probably a destination
+	return i2pkeys.NewI2PAddrFromString(host)
 }
 
 // Dials to an I2P destination and returns a SAMConn, which implements a net.Conn.
+// Failed attempts are retried as the RetryPolicy of the session says, within
+// its Timeout and Deadline.
 func (s *StreamSession) DialI2P(addr i2pkeys.I2PAddr) (*SAMConn, error) {
-	sam, err := NewSAM(s.samAddr)
+	ctx, cancel := s.dialContext(context.Background())
+	defer cancel()
+	return s.dialI2P(ctx, addr)
+}
+
+// one attempt to connect to addr
+func (s *StreamSession) dialOnce(ctx context.Context, addr i2pkeys.I2PAddr) (*SAMConn, error) {
+	sam, err := s.newSAM(ctx)
 	if err != nil {
 		return nil, err
 	}
 	conn := sam.conn
+	if err := s.track(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer s.untrack(conn)
+	stop := watchContext(ctx, conn)
 	_, err = conn.Write([]byte("STREAM CONNECT ID=" + s.id + " DESTINATION=" + addr.Base64() + " SILENT=false\n"))
 	if err != nil {
+		if cerr := stop(); cerr != nil {
+			err = cerr
+		}
 		conn.Close()
-		return nil, err
+		return nil, s.closedErr(err)
 	}
 	buf := make([]byte, 4096)
 	n, err := conn.Read(buf)
+	if cerr := stop(); cerr != nil {
+		conn.Close()
+		return nil, cerr
+	}
 	if err != nil && err != io.EOF {
 		conn.Close()
-		return nil, err
+		return nil, s.closedErr(err)
 	}
 	scanner := bufio.NewScanner(bytes.NewReader(buf[:n]))
 	scanner.Split(bufio.ScanWords)
@@ -226,35 +449,42 @@ func (s *StreamSession) DialI2P(addr i2pkeys.I2PAddr) (*SAMConn, error) {
 		case "STATUS":
 			continue
 		case "RESULT=OK":
-			return &SAMConn{s.keys.Addr(), addr, conn}, nil
+			return s.newConn(s.Addr(), addr, conn)
 		case "RESULT=CANT_REACH_PEER":
 			conn.Close()
-			return nil, errors.New("Can not reach peer")
+			return nil, ErrCantReachPeer
 		case "RESULT=I2P_ERROR":
 			conn.Close()
-			return nil, errors.New("I2P internal error")
+			return nil, ErrI2PError
 		case "RESULT=INVALID_KEY":
 			conn.Close()
-			return nil, errors.New("Invalid key - Stream Session")
+			return nil, &SAMError{"INVALID_KEY", "Invalid key - Stream Session"}
 		case "RESULT=INVALID_ID":
 			conn.Close()
-			return nil, errors.New("Invalid tunnel ID")
+			return nil, ErrInvalidID
 		case "RESULT=TIMEOUT":
 			conn.Close()
-			return nil, errors.New("Timeout")
+			return nil, ErrTimeout
 		default:
 			conn.Close()
 			return nil, errors.New("Unknown error: " + scanner.Text() + " : " + string(buf[:n]))
 		}
 	}
-	panic("sam3 go library error in StreamSession.DialI2P()")
+	// the bridge hung up without an answer, as when it restarts
+	conn.Close()
+	return nil, s.closedErr(io.ErrUnexpectedEOF)
 }
 
-// create a new stream listener to accept inbound connections
+// create a new stream listener to accept inbound connections. The session
+// closes it when it is closed.
 func (s *StreamSession) Listen() (*StreamListener, error) {
-	return &StreamListener{
+	l := &StreamListener{
 		session: s,
 		id:      s.id,
-		laddr:   s.keys.Addr(),
-	}, nil
+		laddr:   s.Addr(),
+	}
+	if err := s.track(l); err != nil {
+		return nil, err
+	}
+	return l, nil
 }
@@ -2,12 +2,16 @@ package sam3
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"log"
 	"net"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/eyedeekay/i2pkeys"
 )
@@ -19,6 +23,14 @@ type StreamListener struct {
 	id string
 	// our local address for this sam socket
 	laddr i2pkeys.I2PAddr
+	// who may connect, nil lets everyone in
+	mu     sync.Mutex
+	access *AccessPolicy
+	// when accepts time out, and the SAM connections of the ones in
+	// progress with their contexts, guarded by mu
+	deadline time.Time
+	pending  map[net.Conn]context.Context
+	closed   bool
 }
 
 func (l *StreamListener) From() string {
@@ -35,9 +47,28 @@ func (l *StreamListener) Addr() net.Addr {
 	return l.laddr
 }
 
-// implements net.Listener
+// implements net.Listener. Accepts in progress return net.ErrClosed, as do
+// new ones. The session and the connections accepted before stay open.
 func (l *StreamListener) Close() error {
-	return l.session.Close()
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	for conn := range pending {
+		conn.Close()
+	}
+	l.session.untrack(l)
+	return nil
+}
+
+// Session returns the session the listener accepts connections of.
+func (l *StreamListener) Session() *StreamSession {
+	return l.session
 }
 
 // implements net.Listener
@@ -45,6 +76,22 @@ func (l *StreamListener) Accept() (net.Conn, error) {
 	return l.AcceptI2P()
 }
 
+// SetAccessPolicy makes the listener check every connection against p before
+// returning it from Accept. Connections p turns away are closed right away.
+// A nil policy lets everyone in.
+func (l *StreamListener) SetAccessPolicy(p *AccessPolicy) {
+	l.mu.Lock()
+	l.access = p
+	l.mu.Unlock()
+}
+
+// AccessPolicy returns the policy set with SetAccessPolicy.
+func (l *StreamListener) AccessPolicy() *AccessPolicy {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.access
+}
+
 func ExtractPairString(input, value string) string {
 	parts := strings.Split(input, " ")
 	for _, part := range parts {
@@ -70,50 +117,124 @@ func ExtractDest(input string) string {
 	return strings.Split(input, " ")[0]
 }
 
+// a connection with data that was read ahead into a bufio.Reader
+type bufferedConn struct {
+	net.Conn
+	rd *bufio.Reader
+}
+
+func (c *bufferedConn) Read(buf []byte) (int, error) {
+	if c.rd != nil {
+		if c.rd.Buffered() > 0 {
+			return c.rd.Read(buf)
+		}
+		c.rd = nil
+	}
+	return c.Conn.Read(buf)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("SAM connection can not be half-closed")
+}
+
+func (c *bufferedConn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return errors.New("SAM connection can not be half-closed")
+}
+
 // accept a new inbound connection
 func (l *StreamListener) AcceptI2P() (*SAMConn, error) {
-	s, err := NewSAM(l.session.samAddr)
-	if err == nil {
-		// we connected to sam
-		// send accept() command
-		_, err = io.WriteString(s.conn, "STREAM ACCEPT ID="+l.id+" SILENT=false\n")
+	return l.acceptI2P(context.Background())
+}
+
+func (l *StreamListener) acceptI2P(ctx context.Context) (*SAMConn, error) {
+	for {
+		conn, err := l.accept(ctx)
 		if err != nil {
-			s.Close()
 			return nil, err
 		}
-		// read reply
-		rd := bufio.NewReader(s.conn)
-		// read first line
-		line, err := rd.ReadString(10)
-		if err != nil {
-			s.Close()
-			return nil, err
+		p := l.AccessPolicy()
+		if p == nil {
+			return conn, nil
 		}
-		log.Println(line)
-		if strings.HasPrefix(line, "STREAM STATUS RESULT=OK") {
-			// we gud read destination line
-			destline, err := rd.ReadString(10)
-			if err == nil {
-				dest := ExtractDest(destline)
-				l.session.from = ExtractPairString(destline, "FROM_PORT")
-				l.session.to = ExtractPairString(destline, "TO_PORT")
-				// return wrapped connection
-				dest = strings.Trim(dest, "\n")
-				return &SAMConn{
-					laddr: l.laddr,
-					raddr: i2pkeys.I2PAddr(dest),
-					conn:  s.conn,
-				}, nil
-			} else {
-				s.Close()
-				return nil, err
-			}
-		} else {
-			s.Close()
-			return nil, errors.New("invalid sam line: " + line)
+		release, err := p.admit(conn.raddr)
+		if err != nil {
+			conn.Close()
+			p.rejected(conn.raddr, err)
+			continue
 		}
-	} else {
+		conn.onClose = release
+		return conn, nil
+	}
+}
+
+func (l *StreamListener) accept(ctx context.Context) (*SAMConn, error) {
+	if l.isClosed() {
+		return nil, net.ErrClosed
+	}
+	if l.expired() {
+		return nil, &acceptTimeout{os.ErrDeadlineExceeded}
+	}
+	s, err := l.newSAM(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// we connected to sam, the session closes it with itself
+	if err := l.session.track(s.conn); err != nil {
+		s.Close()
+		return nil, err
+	}
+	defer l.session.untrack(s.conn)
+	stop, err := l.watch(ctx, s.conn)
+	if err != nil {
 		s.Close()
 		return nil, err
 	}
+	defer stop()
+	// send accept() command
+	_, err = io.WriteString(s.conn, "STREAM ACCEPT ID="+l.id+" SILENT=false\n")
+	if err != nil {
+		s.Close()
+		return nil, l.acceptErr(ctx, err)
+	}
+	// read reply
+	rd := bufio.NewReader(s.conn)
+	// read first line
+	line, err := rd.ReadString(10)
+	if err != nil {
+		s.Close()
+		return nil, l.acceptErr(ctx, err)
+	}
+	log.Println(line)
+	if !strings.HasPrefix(line, "STREAM STATUS RESULT=OK") {
+		s.Close()
+		return nil, errors.New("invalid sam line: " + line)
+	}
+	// we gud read destination line
+	destline, err := rd.ReadString(10)
+	if err != nil {
+		s.Close()
+		return nil, l.acceptErr(ctx, err)
+	}
+	dest := ExtractDest(destline)
+	l.session.from = ExtractPairString(destline, "FROM_PORT")
+	l.session.to = ExtractPairString(destline, "TO_PORT")
+	// return wrapped connection
+	dest = strings.Trim(dest, "\n")
+	var conn net.Conn = s.conn
+	if rd.Buffered() > 0 {
+		// the peer's first bytes came with the destination line
+		conn = &bufferedConn{conn, rd}
+	}
+	if stop() {
+		// ctx was done just as the peer came
+		s.Close()
+		return nil, l.acceptErr(ctx, ctx.Err())
+	}
+	return l.session.newConn(l.laddr, i2pkeys.I2PAddr(dest), conn)
 }
@@ -1,7 +1,9 @@
 package sam3
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"strings"
 	"testing"
@@ -282,3 +284,83 @@ func ExampleStreamListener() {
 	// Output:
 	//Hello world!
 }
+
+func Test_TransientSession(t *testing.T) {
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	sam, err := NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSessionWithSignature("transient", i2pkeys.I2PKeys{}, nil, Sig_ECDSA_SHA256_P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	b.mu.Lock()
+	want := b.transient
+	b.mu.Unlock()
+	if ss.Keys() != want || ss.Addr() != want.Addr() {
+		t.Fatalf("session has keys %v, the bridge made %v", ss.Keys(), want)
+	}
+	p, err := parsePrivateKeys(ss.Keys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.dest.sig.name != "ECDSA_SHA256_P256" {
+		t.Errorf("transient destination has signature type %s", p.dest.sig.name)
+	}
+}
+
+func Test_GeneratedSessionID(t *testing.T) {
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	sam, err := NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	b.dupIDs = 1
+	b.mu.Unlock()
+	if _, err := sam.NewStreamSession("taken", keys, nil); !errors.Is(err, ErrDuplicatedID) {
+		t.Fatalf("session with a taken name: %v, want ErrDuplicatedID", err)
+	}
+
+	sam, err = NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sam.Config.I2PConfig.IDPrefix = "test-"
+	b.mu.Lock()
+	b.dupIDs = 2
+	b.mu.Unlock()
+	ss, err := sam.NewStreamSession("", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if !strings.HasPrefix(ss.ID(), "test-") || len(ss.ID()) != len("test-")+idLen {
+		t.Errorf("generated session name %q", ss.ID())
+	}
+	if n := b.count("SESSION CREATE"); n != 4 {
+		t.Errorf("bridge got %d SESSION CREATEs, want 4", n)
+	}
+}
+
+func Test_DialBridgeHangsUp(t *testing.T) {
+	b, ss := newLifecycleSession(t)
+	defer ss.Close()
+	b.mu.Lock()
+	b.hangups = 1
+	b.mu.Unlock()
+	if _, err := ss.DialI2P(ss.Addr()); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("dial the bridge hung up on: %v, want io.ErrUnexpectedEOF", err)
+	}
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
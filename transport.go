@@ -0,0 +1,223 @@
+package sam3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Dialer opens the connections to the SAM bridge. A *net.Dialer is one, and
+// so is the *wasip1.Dialer of github.com/stealthrocket/net.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DefaultDialer is used when no other Dialer is given. It uses the sockets of
+// wasip1 when built for it, and the net package everywhere else.
+var DefaultDialer Dialer = defaultDialer()
+
+// where and how to reach a SAM bridge, see parseSAMAddress
+type samTransport struct {
+	network string // tcp or unix
+	address string // host:port or the socket path
+	tls     *tls.Config
+	dialer  Dialer
+}
+
+// parseSAMAddress reads the address of a SAM bridge, one of
+//
+//	host:port
+//	tcp://host:port
+//	tls://host:port
+//	unix:///path/to/socket
+//
+// The port is 7656 if left out. The certificate of a tls:// bridge is checked
+// against the system roots, or the CA certificates in a PEM file with
+// ?ca=/path/to/ca.pem, and its name against the host or ?servername=. With
+// ?pin=, which can be repeated, the bridge has to present a certificate whose
+// public key has that SHA-256 hash, in hex or base64. Pinning without a CA
+// accepts self-signed certificates, as stunnel setups often use.
+func parseSAMAddress(addr string, d Dialer) (*samTransport, error) {
+	if d == nil {
+		d = DefaultDialer
+	}
+	t := &samTransport{network: "tcp", dialer: d}
+	if !strings.Contains(addr, "://") {
+		t.address = withSAMPort(addr)
+		return t, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp":
+		t.address = withSAMPort(u.Host)
+	case "unix":
+		t.network = "unix"
+		t.address = u.Path
+		if t.address == "" {
+			return nil, errors.New("SAM address without a socket path: " + addr)
+		}
+	case "tls":
+		t.address = withSAMPort(u.Host)
+		t.tls, err = samTLSConfig(u)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Unknown SAM address scheme " + u.Scheme + ", use tcp, tls or unix")
+	}
+	return t, nil
+}
+
+// adds the default host or port where they are left out
+func withSAMPort(hostport string) string {
+	host, port, err := splitSAMAddress(hostport)
+	if err != nil {
+		// let dialing fail with a proper error
+		return hostport
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// splitSAMAddress splits host:port, [ipv6]:port, a bare hostname or IP
+// address, or :port, filling in SAM_HOST and SAM_PORT for what is left out.
+// A tcp:// or tls:// prefix is ignored.
+func splitSAMAddress(addr string) (host, port string, err error) {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		switch addr[:i] {
+		case "tcp", "tls":
+			u, err := url.Parse(addr)
+			if err != nil {
+				return "", "", err
+			}
+			addr = u.Host
+		default:
+			return "", "", errors.New("SAM address " + addr + " has no host and port")
+		}
+	}
+	host, port, err = net.SplitHostPort(addr)
+	if err != nil {
+		// no port, and maybe an IPv6 address without brackets
+		host, port = addr, ""
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+		if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+			return "", "", errors.New("Invalid SAM address " + addr)
+		}
+	}
+	if host == "" {
+		host = SAM_HOST
+	}
+	if port == "" {
+		port = SAM_PORT
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", "", errors.New("Invalid port in SAM address " + addr)
+	}
+	return host, port, nil
+}
+
+func samTLSConfig(u *url.URL) (*tls.Config, error) {
+	q := u.Query()
+	cfg := &tls.Config{ServerName: u.Hostname()}
+	if name := q.Get("servername"); name != "" {
+		cfg.ServerName = name
+	}
+	if ca := q.Get("ca"); ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates in " + ca)
+		}
+	}
+	var pins [][]byte
+	for _, p := range q["pin"] {
+		pin, err := parsePin(p)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+	if len(pins) == 0 {
+		return cfg, nil
+	}
+	if cfg.RootCAs == nil {
+		// the pin is all there is to trust
+		cfg.InsecureSkipVerify = true
+	}
+	cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+		if len(raw) == 0 {
+			return errors.New("SAM bridge sent no certificate")
+		}
+		leaf, err := x509.ParseCertificate(raw[0])
+		if err != nil {
+			return err
+		}
+		h := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(pin, h[:]) {
+				return nil
+			}
+		}
+		return errors.New("SAM bridge certificate does not match the pinned key")
+	}
+	return cfg, nil
+}
+
+// reads a SHA-256 pin in hex or base64
+func parsePin(p string) ([]byte, error) {
+	p = strings.TrimPrefix(p, "sha256:")
+	if b, err := hex.DecodeString(p); err == nil && len(b) == sha256.Size {
+		return b, nil
+	}
+	// an unescaped + in a query reads as a space
+	p = strings.Replace(p, " ", "+", -1)
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(p); err == nil && len(b) == sha256.Size {
+			return b, nil
+		}
+	}
+	return nil, errors.New("Invalid SAM certificate pin " + p + ", expected a SHA-256 hash in hex or base64")
+}
+
+// dial connects to the bridge, including the TLS handshake
+func (t *samTransport) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := t.dialer.DialContext(ctx, t.network, t.address)
+	if err != nil || t.tls == nil {
+		return conn, err
+	}
+	tc := tls.Client(conn, t.tls)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// sessionTransport is the transport of the sessions made by sam, with the
+// Dialer of its config in case it was set after sam connected
+func (sam *SAM) sessionTransport() *samTransport {
+	t := sam.transport
+	if d := sam.Config.I2PConfig.Dialer; d != nil {
+		c := *t
+		c.dialer = d
+		t = &c
+	}
+	return t
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package sam3
+
+import "net"
+
+func defaultDialer() Dialer {
+	return &net.Dialer{}
+}
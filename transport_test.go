@@ -0,0 +1,254 @@
+package sam3
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a Dialer that counts its connections
+type countingDialer struct {
+	net.Dialer
+	mu sync.Mutex
+	n  int
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.n++
+	d.mu.Unlock()
+	return d.Dialer.DialContext(ctx, network, address)
+}
+
+func (d *countingDialer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.n
+}
+
+// a Dialer that can not reach anything
+type failingDialer struct{}
+
+var errUnreachable = errors.New("unreachable")
+
+func (failingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, errUnreachable
+}
+
+func Test_NewSAMWithDialer(t *testing.T) {
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	d := &countingDialer{}
+	sam, err := NewSAMWithDialer(b.l.Addr().String(), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSession("", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := d.count(); n != 2 {
+		// the SAM and the dial
+		t.Errorf("Dialer made %d connections, want 2", n)
+	}
+
+	if _, err := NewSAMWithDialer(b.l.Addr().String(), failingDialer{}); !errors.Is(err, errUnreachable) {
+		t.Errorf("NewSAMWithDialer with a failing Dialer: %v", err)
+	}
+	if DefaultDialer == nil {
+		t.Error("no DefaultDialer")
+	}
+}
+
+func Test_SessionDialer(t *testing.T) {
+	b := newFakeBridge(t, "tcp", "127.0.0.1:0")
+	sam, err := NewSAM(b.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &countingDialer{}
+	sam.Config.I2PConfig.Dialer = d
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSession("", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := d.count(); n != 1 {
+		t.Errorf("the Dialer of the config made %d connections, want 1", n)
+	}
+}
+
+func Test_ParseSAMAddress(t *testing.T) {
+	for _, tt := range []struct {
+		addr, network, address string
+		tls                    bool
+	}{
+		{"127.0.0.1:7000", "tcp", "127.0.0.1:7000", false},
+		{"tcp://sam.local", "tcp", "sam.local:7656", false},
+		{"tls://[::1]:7667", "tcp", "[::1]:7667", true},
+		{"unix:///run/i2p/sam.sock", "unix", "/run/i2p/sam.sock", false},
+	} {
+		tr, err := parseSAMAddress(tt.addr, nil)
+		if err != nil {
+			t.Errorf("parseSAMAddress(%q): %v", tt.addr, err)
+			continue
+		}
+		if tr.network != tt.network || tr.address != tt.address || (tr.tls != nil) != tt.tls || tr.dialer != DefaultDialer {
+			t.Errorf("parseSAMAddress(%q) = %s %s tls %v", tt.addr, tr.network, tr.address, tr.tls != nil)
+		}
+	}
+	for _, addr := range []string{"udp://127.0.0.1:7655", "unix://", "tls://127.0.0.1?pin=abc", "tls://127.0.0.1?ca=/nonexistent.pem"} {
+		if _, err := parseSAMAddress(addr, nil); err == nil {
+			t.Errorf("parseSAMAddress(%q) did not fail", addr)
+		}
+	}
+}
+
+func Test_UnixBridge(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "sam.sock")
+	b := newFakeBridge(t, "unix", sock)
+	sam, err := NewSAM("unix://" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSession("", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := b.count("STREAM CONNECT"); n != 1 {
+		t.Errorf("bridge got %d STREAM CONNECTs, want 1", n)
+	}
+}
+
+// a self-signed certificate for 127.0.0.1, which is its own CA
+func newTestCert(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sam bridge"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func Test_TLSBridge(t *testing.T) {
+	tlsCert, cert := newTestCert(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{tlsCert}})
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	b := serveFakeBridge(t, l)
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	other := sha256.Sum256([]byte("some other key"))
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	base := "tls://" + l.Addr().String()
+
+	for _, tt := range []struct {
+		query string
+		ok    bool
+	}{
+		{"", false},
+		{"?pin=" + hex.EncodeToString(hash[:]), true},
+		{"?pin=sha256:" + base64.URLEncoding.EncodeToString(hash[:]), true},
+		{"?pin=" + hex.EncodeToString(other[:]), false},
+		{"?pin=" + hex.EncodeToString(other[:]) + "&pin=" + hex.EncodeToString(hash[:]), true},
+		{"?ca=" + ca, true},
+		{"?ca=" + ca + "&servername=elsewhere.test", false},
+		{"?ca=" + ca + "&pin=" + hex.EncodeToString(other[:]), false},
+	} {
+		sam, err := NewSAM(base + tt.query)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewSAM(%q): %v, want success %v", base+tt.query, err, tt.ok)
+		}
+		if err == nil {
+			sam.Close()
+		}
+	}
+
+	sam, err := NewSAM(base + "?pin=" + hex.EncodeToString(hash[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSession("", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	conn, err := ss.DialI2P(ss.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := b.count("STREAM CONNECT"); n != 1 {
+		t.Errorf("bridge got %d STREAM CONNECTs, want 1", n)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build wasip1
+
+package sam3
+
+import "github.com/stealthrocket/net/wasip1"
+
+func defaultDialer() Dialer {
+	return &wasip1.Dialer{}
+}
@@ -0,0 +1,144 @@
+package sam3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eyedeekay/i2pkeys"
+)
+
+var b32enc = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// how many candidates a worker tries before it yields, so progress reports and
+// cancellation get a turn on single-threaded wasip1 too
+const vanityBatch = 4096
+
+// VanityProgress reports how a search for vanity keys is going.
+type VanityProgress struct {
+	// destinations hashed so far
+	Tried uint64
+	// time since the search started
+	Elapsed time.Duration
+	// how many destinations are hashed on average before a match
+	Expected float64
+}
+
+// NewVanityKeys searches for EdDSA_SHA512_Ed25519 keys whose .b32.i2p address
+// starts with prefix. The keys are generated locally, the router is not asked.
+// The search is spread over workers goroutines (GOMAXPROCS if workers < 1)
+// and runs until a match is found or ctx is done. If progress is not nil it is
+// called about once a second while searching.
+//
+// Every letter of the prefix makes the search 32 times longer, expect seconds
+// for 4 letters and hours for 7.
+func NewVanityKeys(ctx context.Context, prefix string, workers int, progress func(VanityProgress)) (i2pkeys.I2PKeys, error) {
+	return NewVanityKeysWithCrypto(ctx, prefix, Crypto_ELGAMAL, workers, progress)
+}
+
+// NewVanityKeysWithCrypto is NewVanityKeys with a choice of encryption type,
+// Crypto_ELGAMAL or Crypto_ECIES_X25519.
+func NewVanityKeysWithCrypto(ctx context.Context, prefix, cryptoType string, workers int, progress func(VanityProgress)) (i2pkeys.I2PKeys, error) {
+	prefix = strings.ToLower(strings.TrimSuffix(prefix, ".b32.i2p"))
+	if prefix == "" {
+		return i2pkeys.I2PKeys{}, errors.New("Empty vanity prefix")
+	}
+	if len(prefix) > 52 {
+		return i2pkeys.I2PKeys{}, errors.New("Vanity prefix longer than a b32 address")
+	}
+	for _, c := range prefix {
+		if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyz234567", c) {
+			return i2pkeys.I2PKeys{}, errors.New("Vanity prefix can only use a-z and 2-7, not " + string(c))
+		}
+	}
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	sig, _ := lookupSigSpec(7)
+	crypto, err := parseCryptoType(cryptoType)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		tried uint64
+		once  sync.Once
+		found *privateKeys
+		ferr  error
+		wg    sync.WaitGroup
+	)
+	finish := func(p *privateKeys, err error) {
+		once.Do(func() {
+			found, ferr = p, err
+			cancel()
+		})
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := newPrivateKeys(sig, crypto, nil)
+			if err != nil {
+				finish(nil, err)
+				return
+			}
+			// the padding is free to choose, so vary it instead of generating
+			// new keys for every candidate
+			counter := p.dest.padding()[:8]
+			n := binary.BigEndian.Uint64(counter)
+			buf := make([]byte, b32enc.EncodedLen(sha256.Size))
+			for {
+				for j := 0; j < vanityBatch; j++ {
+					n++
+					binary.BigEndian.PutUint64(counter, n)
+					h := sha256.Sum256(p.dest.raw)
+					b32enc.Encode(buf, h[:])
+					if string(buf[:len(prefix)]) == prefix {
+						atomic.AddUint64(&tried, uint64(j+1))
+						finish(p, nil)
+						return
+					}
+				}
+				atomic.AddUint64(&tried, vanityBatch)
+				select {
+				case <-ctx.Done():
+					return
+				default:
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	if progress != nil {
+		start := time.Now()
+		expected := math.Pow(32, float64(len(prefix)))
+		go func() {
+			t := time.NewTicker(time.Second)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					progress(VanityProgress{atomic.LoadUint64(&tried), time.Since(start), expected})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	finish(nil, ctx.Err())
+	if ferr != nil {
+		return i2pkeys.I2PKeys{}, ferr
+	}
+	return found.Keys(), nil
+}
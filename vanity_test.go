@@ -0,0 +1,65 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_NewVanityKeys(t *testing.T) {
+	keys, err := NewVanityKeys(context.Background(), "AB.b32.i2p", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(keys.Addr().Base32(), "ab") {
+		t.Errorf("vanity address %s does not start with ab", keys.Addr().Base32())
+	}
+	p, err := parsePrivateKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.dest.sig.name != "EdDSA_SHA512_Ed25519" {
+		t.Errorf("vanity keys have signature type %s", p.dest.sig.name)
+	}
+	msg := []byte("vanity")
+	s, err := sign(p.dest.sig, p.sigPriv, msg)
+	if err != nil || !verify(p.dest.sig, p.dest.sigPub, msg, s) {
+		t.Errorf("vanity keys do not sign for their destination: %v", err)
+	}
+
+	keys, err = NewVanityKeysWithCrypto(context.Background(), "a", Crypto_ECIES_X25519, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := parsePrivateKeys(keys); err != nil || p.dest.crypto.name != "ECIES_X25519" || !strings.HasPrefix(keys.Addr().Base32(), "a") {
+		t.Errorf("vanity keys with ECIES_X25519: %s, %v", keys.Addr().Base32(), err)
+	}
+
+	for _, prefix := range []string{"", "ab1", "a-b", strings.Repeat("a", 53)} {
+		if _, err := NewVanityKeys(context.Background(), prefix, 1, nil); err == nil {
+			t.Errorf("NewVanityKeys accepted prefix %q", prefix)
+		}
+	}
+}
+
+func Test_NewVanityKeysCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	var reports int32
+	// a search that will not finish in time
+	_, err := NewVanityKeys(ctx, "aaaaaaaaaa", 1, func(p VanityProgress) {
+		atomic.AddInt32(&reports, 1)
+		if p.Tried == 0 || p.Expected != 1<<50 {
+			t.Errorf("progress %+v", p)
+		}
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("canceled search: %v, want context.DeadlineExceeded", err)
+	}
+	if atomic.LoadInt32(&reports) == 0 {
+		t.Error("no progress reported")
+	}
+}